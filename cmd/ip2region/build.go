@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hokitlee/go-ip2region/maker"
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+// stringsFlag collects the values of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func runBuild(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("build", "[flags] -o db\n\nbuilds a db from GeoLite2 csv, MaxMind DB, qqwry.dat and ip.merge.txt datasets,\nread in that order; later datasets and overlays replace the ipv4 ranges of earlier ones where they overlap")
+	out := fs.String("o", "", "output db `path`")
+	var merges, overlays stringsFlag
+	fs.Var(&merges, "merge", "dataset in the ip.merge.txt format, may be repeated")
+	qqwry := fs.String("qqwry", "", "dataset in the qqwry.dat format, read before the -merge datasets")
+	var geoCity, geoASN stringsFlag
+	geoLocations := fs.String("geolite2-locations", "", "GeoLite2-City-Locations csv naming the networks of -geolite2-city")
+	fs.Var(&geoCity, "geolite2-city", "GeoLite2-City-Blocks-IPv4 or -IPv6 csv, may be repeated")
+	fs.Var(&geoASN, "geolite2-asn", "GeoLite2-ASN-Blocks-IPv4 or -IPv6 csv, may be repeated")
+	var mmdbSources stringsFlag
+	fs.Var(&mmdbSources, "mmdb-source", "dataset in the MaxMind DB format, may be repeated")
+	fs.Var(&overlays, "overlay", "ranges in the ip.merge.txt format merged over the datasets, may be repeated")
+	area := fs.String("area", "", "region and province code table like data/area_code.csv")
+	isp := fs.String("isp", "", "isp code table like data/isp_code.csv")
+	version := fs.String("version", "", "dataset version recorded in the db metadata")
+	vector := fs.Bool("vector", false, "write a vector index")
+	mmdb := fs.String("mmdb", "", "also write the ranges as a MaxMind DB at `path`")
+	upstream := fs.Bool("upstream", false, "write the data blocks in the upstream ip2region v1 layout")
+	xdb := fs.Bool("xdb", false, "write an upstream ip2region xdb file of the ipv4 ranges instead of a db")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *out == "" || (len(merges) == 0 && *qqwry == "" && len(geoCity) == 0 && len(geoASN) == 0 && len(mmdbSources) == 0) || fs.NArg() != 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	opts := []maker.Option{}
+	if *vector {
+		opts = append(opts, maker.WithVectorIndex())
+	}
+	if *mmdb != "" {
+		opts = append(opts, maker.WithMMDB(*mmdb))
+	}
+	if *upstream {
+		opts = append(opts, maker.WithUpstreamLayout())
+	}
+	if *xdb {
+		opts = append(opts, maker.WithXdb())
+	}
+
+	var rm, pm, im map[string]int
+	if *area != "" {
+		err := readFile(*area, func(r io.Reader) (err error) {
+			rm, pm, err = maker.ReadAreaCodes(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		opts = append(opts, maker.WithCodeTable(filepath.Base(*area), ""))
+	}
+	if *isp != "" {
+		err := readFile(*isp, func(r io.Reader) (err error) {
+			im, err = maker.ReadISPCodes(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		opts = append(opts, maker.WithCodeTable(filepath.Base(*isp), ""))
+	}
+
+	var b ranges
+	if len(geoCity) > 0 || len(geoASN) > 0 {
+		g := &maker.GeoLite2{ProvinceCodes: pm}
+		var files []string
+		if *geoLocations != "" {
+			if err := readFile(*geoLocations, g.ReadLocations); err != nil {
+				return err
+			}
+			files = append(files, *geoLocations)
+		}
+		for _, path := range geoCity {
+			if err := readFile(path, g.ReadCityBlocks); err != nil {
+				return err
+			}
+			files = append(files, path)
+		}
+		for _, path := range geoASN {
+			if err := readFile(path, g.ReadASNBlocks); err != nil {
+				return err
+			}
+			files = append(files, path)
+		}
+		if err := b.add(g.Metadata()); err != nil {
+			return err
+		}
+		for _, path := range files {
+			opts = append(opts, maker.WithSource(filepath.Base(path), *version))
+		}
+	}
+	for _, path := range mmdbSources {
+		mds, err := maker.ReadMMDB(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := b.add(mds); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		opts = append(opts, maker.WithSource(filepath.Base(path), *version))
+	}
+	if *qqwry != "" {
+		if _, err := os.Stat(*qqwry); err != nil {
+			return err
+		}
+		mds, err := maker.NewQQwry(*qqwry).GetQQWryIpRecord(pm)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *qqwry, err)
+		}
+		if err := b.add(mds); err != nil {
+			return fmt.Errorf("%s: %w", *qqwry, err)
+		}
+		opts = append(opts, maker.WithSource(filepath.Base(*qqwry), *version))
+	}
+	for _, path := range append(merges, overlays...) {
+		var mds []maker.Metadata
+		err := readFile(path, func(r io.Reader) (err error) {
+			mds, err = maker.ReadMerge(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := b.add(mds); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		opts = append(opts, maker.WithSource(filepath.Base(path), *version))
+	}
+
+	v6, err := b.sorted6()
+	if err != nil {
+		return err
+	}
+	if err := maker.NewMaker(*out, append(b.v4, v6...), rm, pm, im, opts...).Make(); err != nil {
+		return err
+	}
+	var verr *ip2region.VerifyError
+	if err := ip2region.Verify(*out); errors.As(err, &verr) {
+		return fmt.Errorf("%s: %d problem(s), first: %s", *out, len(verr.Problems), verr.Problems[0])
+	} else if err != nil {
+		return err
+	}
+	if *xdb {
+		// xdb files hold no ipv6 ranges, Make leaves them out
+		fmt.Fprintf(stdout, "%s: %d ipv4 ranges, %d ipv6 ranges skipped\n", *out, len(b.v4), len(v6))
+		return nil
+	}
+	fmt.Fprintf(stdout, "%s: %d ipv4 ranges, %d ipv6 ranges\n", *out, len(b.v4), len(v6))
+	return nil
+}
+
+// ranges combines datasets, the ipv4 ranges of each one are merged over
+// the previous ones.
+type ranges struct {
+	v4, v6 []maker.Metadata
+}
+
+func (b *ranges) add(mds []maker.Metadata) error {
+	var v4 []maker.Metadata
+	for _, md := range mds {
+		if maker.IsIPv6(md.StartIP) {
+			b.v6 = append(b.v6, md)
+		} else {
+			v4 = append(v4, md)
+		}
+	}
+	if len(b.v4) == 0 {
+		b.v4 = v4
+	} else if len(v4) > 0 {
+		merged, err := maker.MergeMetadataErr(b.v4, v4)
+		if err != nil {
+			return err
+		}
+		b.v4 = merged
+	}
+	return nil
+}
+
+// sorted6 returns the ipv6 ranges sorted by start ip.
+func (b *ranges) sorted6() ([]maker.Metadata, error) {
+	starts := make(map[string][]byte, len(b.v6))
+	for _, md := range b.v6 {
+		ip, err := maker.IpString2IPv6(md.StartIP)
+		if err != nil {
+			return nil, err
+		}
+		starts[md.StartIP] = ip
+	}
+	sort.SliceStable(b.v6, func(i, j int) bool {
+		return bytes.Compare(starts[b.v6[i].StartIP], starts[b.v6[j].StartIP]) < 0
+	})
+	return b.v6, nil
+}
+
+func readFile(path string, fn func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := fn(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
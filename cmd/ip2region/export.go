@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+func runExport(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("export", "[flags] db\n\nwrites every range of a db or MaxMind DB with its cidrs and every IpInfo field")
+	format := fs.String("format", "csv", "output format: csv, tsv or jsonl")
+	out := fs.String("o", "", "output `file`, stdout by default")
+	area := fs.String("area", "", "region and province code table for the ids of upstream v1 dbs")
+	isp := fs.String("isp", "", "isp code table for the ids of upstream v1 dbs")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	f, err := ip2region.ParseExportFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	codes, err := readCodeTables(*area, *isp)
+	if err != nil {
+		return err
+	}
+	s, err := ip2region.NewSearcher(fs.Arg(0), ip2region.WithCodeTables(codes))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	region, ok := s.(interface {
+		Export(w io.Writer, format ip2region.ExportFormat) error
+	})
+	if !ok {
+		return fmt.Errorf("%s: cannot export a %T", fs.Arg(0), s)
+	}
+
+	if *out == "" {
+		return region.Export(stdout, f)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := region.Export(file, f); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+func runInspect(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("inspect", "[flags] db\n\nprints the super block, header blocks, sections, metadata and trailer of a db")
+	headers := fs.Bool("headers", false, "print every header block entry")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	region, err := ip2region.New(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer region.Close()
+	l, err := region.Layout()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "file:             %s\n", fs.Arg(0))
+	fmt.Fprintf(stdout, "size:             %d\n", l.Size)
+	fmt.Fprintf(stdout, "first index ptr:  %d\n", l.FirstIndexPtr)
+	fmt.Fprintf(stdout, "last index ptr:   %d\n", l.LastIndexPtr)
+	fmt.Fprintf(stdout, "index blocks:     %d\n", l.IndexBlocks)
+	fmt.Fprintf(stdout, "ipv6 blocks:      %d\n", l.IPv6Blocks)
+	if l.Upstream {
+		fmt.Fprintf(stdout, "data layout:      upstream v1\n")
+	}
+	fmt.Fprintf(stdout, "header blocks:    %d\n", len(l.Headers))
+	if *headers {
+		for i, h := range l.Headers {
+			fmt.Fprintf(stdout, "  %4d  %-15s  %d\n", i, h.StartIP, h.IndexPtr)
+		}
+	}
+	fmt.Fprintf(stdout, "sections:         %d\n", len(l.Sections))
+	for _, s := range l.Sections {
+		fmt.Fprintf(stdout, "  %s  offset %d  length %d\n", s.Tag, s.Offset, s.Length)
+	}
+	if md := region.Metadata(); md != nil {
+		b, err := json.MarshalIndent(md, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "metadata:\n  %s\n", b)
+	}
+	fmt.Fprintf(stdout, "trailer:          %q\n", l.Trailer)
+	return nil
+}
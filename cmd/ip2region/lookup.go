@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+// result is one line of lookup output.
+type result struct {
+	IP         string `json:"ip"`
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Country    string `json:"country"`
+	Province   string `json:"province"`
+	City       string `json:"city"`
+	ISP        string `json:"isp"`
+	RegionId   int64  `json:"region_id"`
+	ProvinceId int64  `json:"province_id"`
+	ISPId      int64  `json:"isp_id"`
+}
+
+type failure struct {
+	IP    string `json:"ip"`
+	Error string `json:"error"`
+}
+
+var tsvColumns = []string{"ip", "start", "end", "country", "province", "city", "isp", "region_id", "province_id", "isp_id", "error"}
+
+func runLookup(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("lookup", "[flags] [ip...]\n\nsearches the ips given as arguments, or one per line from stdin")
+	db := fs.String("db", "ip2region.db", "db file, or an http(s) url searched with range requests")
+	algorithm := fs.String("algorithm", "btree", "search algorithm: btree, binary, memory or mmap")
+	format := fs.String("format", "text", "output format: text, json or tsv")
+	area := fs.String("area", "", "region and province code table for the ids of upstream v1 dbs")
+	isp := fs.String("isp", "", "isp code table for the ids of upstream v1 dbs")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	switch *format {
+	case "text", "json", "tsv":
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	codes, err := readCodeTables(*area, *isp)
+	if err != nil {
+		return err
+	}
+	s, err := openSearcher(*db, *algorithm, codes)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	if *format == "tsv" {
+		fmt.Fprintln(w, strings.Join(tsvColumns, "\t"))
+	}
+
+	failed, total := 0, 0
+	lookup := func(ip string) error {
+		total++
+		r, err := search(s, ip)
+		if err != nil && !errors.Is(err, ip2region.ErrNotFound) {
+			failed++
+		}
+		return writeResult(w, *format, r, err)
+	}
+
+	if fs.NArg() > 0 {
+		for _, ip := range fs.Args() {
+			if err := lookup(ip); err != nil {
+				return err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			ip := strings.TrimSpace(scanner.Text())
+			if ip == "" || strings.HasPrefix(ip, "#") {
+				continue
+			}
+			if err := lookup(ip); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d lookups failed", failed, total)
+	}
+	return nil
+}
+
+// openSearcher opens db, a path or an http(s) url, for algorithm. MaxMind
+// DB files are searched with their default mapping.
+func openSearcher(db, algorithm string, codes *ip2region.CodeTables) (ip2region.RangeSearcher, error) {
+	a, err := ip2region.ParseAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	opts := []ip2region.Option{ip2region.WithAlgorithm(a), ip2region.WithCodeTables(codes)}
+
+	if strings.HasPrefix(db, "http://") || strings.HasPrefix(db, "https://") {
+		r, err := ip2region.NewHTTPReaderAt(db)
+		if err != nil {
+			return nil, err
+		}
+		s, err := ip2region.NewSearcherFromReaderAt(r, r.Size(), opts...)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return remoteSearcher{s.(ip2region.RangeSearcher), r}, nil
+	}
+	s, err := ip2region.NewSearcher(db, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.(ip2region.RangeSearcher), nil
+}
+
+// remoteSearcher is a searcher of an http(s) db, Close also closes the
+// HTTPReaderAt it reads.
+type remoteSearcher struct {
+	ip2region.RangeSearcher
+	r *ip2region.HTTPReaderAt
+}
+
+func (s remoteSearcher) Close() error {
+	err := s.RangeSearcher.Close()
+	if cerr := s.r.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readCodeTables reads the code tables at the area and isp paths, either
+// may be empty. Without both it returns nil.
+func readCodeTables(area, isp string) (*ip2region.CodeTables, error) {
+	if area == "" && isp == "" {
+		return nil, nil
+	}
+	var areaFile, ispFile io.Reader
+	if area != "" {
+		f, err := os.Open(area)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		areaFile = f
+	}
+	if isp != "" {
+		f, err := os.Open(isp)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		ispFile = f
+	}
+	return ip2region.ReadCodeTables(areaFile, ispFile)
+}
+
+// search searches ip, which may carry a port, brackets or a zone.
+func search(s ip2region.RangeSearcher, ip string) (result, error) {
+	res := result{IP: ip}
+	addr, err := ip2region.NormalizeAddr(ip)
+	if err != nil {
+		return res, err
+	}
+	r, err := s.SearchAddrRange(addr)
+	if err != nil {
+		return res, err
+	}
+	res.Start, res.End = r.Start.String(), r.End.String()
+	res.Country, res.Province, res.City, res.ISP = r.Country, r.Province, r.City, r.ISP
+	res.RegionId, res.ProvinceId, res.ISPId = r.RegionId, r.ProvinceId, r.ISPId
+	return res, nil
+}
+
+func writeResult(w io.Writer, format string, r result, err error) error {
+	switch format {
+	case "json":
+		var v interface{} = r
+		if err != nil {
+			v = failure{IP: r.IP, Error: err.Error()}
+		}
+		b, merr := json.Marshal(v)
+		if merr != nil {
+			return merr
+		}
+		_, werr := fmt.Fprintf(w, "%s\n", b)
+		return werr
+	case "tsv":
+		fields := []string{r.IP, r.Start, r.End, r.Country, r.Province, r.City, r.ISP,
+			strconv.FormatInt(r.RegionId, 10), strconv.FormatInt(r.ProvinceId, 10), strconv.FormatInt(r.ISPId, 10), ""}
+		if err != nil {
+			fields = append([]string{r.IP}, make([]string, len(tsvColumns)-2)...)
+			fields = append(fields, err.Error())
+		}
+		_, werr := fmt.Fprintln(w, strings.Join(fields, "\t"))
+		return werr
+	default:
+		if err != nil {
+			_, werr := fmt.Fprintf(w, "%s error: %v\n", r.IP, err)
+			return werr
+		}
+		_, werr := fmt.Fprintf(w, "%s %s|%s|%s|%s|%d|%d|%d\n", r.IP,
+			r.Country, r.Province, r.City, r.ISP, r.RegionId, r.ProvinceId, r.ISPId)
+		return werr
+	}
+}
@@ -0,0 +1,96 @@
+// Command ip2region works with ip2region db files.
+//
+// Usage:
+//
+//	ip2region <command> [flags] [args]
+//
+// The commands are:
+//
+//	lookup    search ips given as arguments or read from stdin
+//	inspect   print the super block, header blocks and sections of a db
+//	verify    check that db files are well formed
+//	build     build a db from datasets and code tables
+//	export    write every range of a db as csv, tsv or json lines
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+type command struct {
+	name  string
+	short string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = []command{
+	{"lookup", "search ips given as arguments or read from stdin", runLookup},
+	{"inspect", "print the super block, header blocks and sections of a db", runInspect},
+	{"verify", "check that db files are well formed", runVerify},
+	{"build", "build a db from datasets and code tables", runBuild},
+	{"export", "write every range of a db as csv, tsv or json lines", runExport},
+}
+
+// errUsage is returned by commands after printing their usage.
+var errUsage = errors.New("usage")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ip2region <command> [flags] [args]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun 'ip2region <command> -h' for the flags of a command\n")
+}
+
+// newFlagSet returns the flag set of a command, its usage line is printed
+// before the flags.
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ip2region %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and turns -h and flag errors into
+// errUsage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(os.Args[2:], os.Stdin, os.Stdout)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ip2region %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ip2region: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+const testDB = "testdata/ip2region.db"
+
+func run(t *testing.T, fn func([]string, io.Reader, io.Writer) error, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := fn(args, strings.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+func TestLookup(t *testing.T) {
+	out, err := run(t, runLookup, "", "-db", testDB, "1.2.3.4", "2001:db8::5")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := "1.2.3.4 中国|广东|深圳市|电信|440000|44|3\n2001:db8::5 中国|北京|北京市|联通|110000|11|2\n"
+	if out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+
+	for _, algorithm := range []string{"btree", "binary", "memory", "mmap"} {
+		out, err = run(t, runLookup, "1.0.0.1\n\n# comment\n 10.1.1.1:80 \n", "-db", testDB, "-algorithm", algorithm, "-format", "json")
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("%s: got %q", algorithm, out)
+		}
+		var r result
+		if err := json.Unmarshal([]byte(lines[1]), &r); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if r.IP != "10.1.1.1:80" || r.Start != "10.0.0.0" || r.End != "10.255.255.255" || r.ISP != "内网IP" {
+			t.Fatalf("%s: got %+v", algorithm, r)
+		}
+	}
+
+	out, err = run(t, runLookup, "", "-db", testDB, "-format", "tsv", "1.0.0.1", "1.2.3.x", "2001:db9::")
+	if err == nil {
+		t.Fatal("expected an error for an invalid ip")
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 || lines[0] != strings.Join(tsvColumns, "\t") {
+		t.Fatalf("got %q", out)
+	}
+	for i, want := range []string{"", "ip format error", "not found"} {
+		fields := strings.Split(lines[i+1], "\t")
+		if len(fields) != len(tsvColumns) || !strings.HasPrefix(fields[len(fields)-1], want) {
+			t.Fatalf("line %d: %q", i+1, lines[i+1])
+		}
+	}
+
+	if _, err := run(t, runLookup, "", "-db", testDB, "-format", "xml", "1.0.0.1"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestLookup_remote(t *testing.T) {
+	var active int64
+	srv := httptest.NewUnstartedServer(http.FileServer(http.Dir("testdata")))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt64(&active, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt64(&active, -1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	out, err := run(t, runLookup, "", "-db", srv.URL+"/ip2region.db", "1.2.3.4")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := "1.2.3.4 中国|广东|深圳市|电信|440000|44|3\n"; out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+	// Close released the reader and its idle connections
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt64(&active) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections left open", atomic.LoadInt64(&active))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	out, err := run(t, runInspect, "", "-headers", testDB)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, want := range []string{"index blocks:     5", "ipv6 blocks:      1", "META", `"name": "test"`, "Created by PPIO"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := ioutil.ReadFile(testDB)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	broken := filepath.Join(dir, "broken.db")
+	if err := ioutil.WriteFile(broken, b[:len(b)/2], 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := run(t, runVerify, "", testDB)
+	if err != nil || out != testDB+": ok\n" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	out, err = run(t, runVerify, "", testDB, broken)
+	if err == nil || !strings.Contains(out, broken+": super block") {
+		t.Fatalf("got %q, %v", out, err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"ip.merge.txt": "0.0.0.0|0.255.255.255|0|0|0|0|0\n" +
+			"1.0.0.0|9.255.255.255|中国|0|广东|深圳市|电信\n" +
+			"10.0.0.0|255.255.255.255|美国|0|0|0|0\n" +
+			"2001:db8:1::|2001:db8:1::ffff|美国|0|0|0|0\n",
+		"v6.txt":      "2001:db8::|2001:db8::ffff|中国|0|北京|北京市|联通\n",
+		"overlay.txt": "1.2.3.0|1.2.3.255|中国|0|北京|北京市|联通\n",
+		"area.csv":    "0,0,未知\n110000,11,北京\n440000,44,广东\n",
+		"isp.csv":     "0,0,其他\n2,2,联通\n3,3,电信\n",
+		"bad.txt":     "1.0.0.0|1.0.0.x|中国|0|广东|深圳市|电信\n",
+		"reverse.txt": "1.0.0.9|1.0.0.0|中国|0|广东|深圳市|电信\n",
+		"short.txt":   "1.0.0.0|1.0.0.255|中国\n",
+		"badarea.csv": "0,x,未知\n",
+		"locations.csv": "geoname_id,country_name,subdivision_1_name,city_name\n" +
+			"1,中国,广东省,深圳市\n",
+		"city.csv": "network,geoname_id,registered_country_geoname_id\n" +
+			"1.0.0.0/8,1,1\n",
+		"asn.csv": "network,autonomous_system_number,autonomous_system_organization\n" +
+			"1.2.0.0/16,4134,CHINANET-BACKBONE\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	db := path("out.db")
+
+	out, err := run(t, runBuild, "", "-o", db, "-merge", path("ip.merge.txt"), "-merge", path("v6.txt"),
+		"-overlay", path("overlay.txt"), "-area", path("area.csv"), "-isp", path("isp.csv"), "-vector", "-version", "20240501", "-mmdb", path("out.mmdb"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(out, "5 ipv4 ranges, 2 ipv6 ranges") {
+		t.Fatalf("got %q", out)
+	}
+
+	out, err = run(t, runLookup, "", "-db", path("out.mmdb"), "1.2.3.4", "2001:db8::1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := "1.2.3.4 中国|北京|北京市|联通|110000|11|2\n2001:db8::1 中国|北京|北京市|联通|110000|11|2\n"; out != want {
+		t.Fatalf("mmdb lookup got %q, want %q", out, want)
+	}
+	out, err = run(t, runExport, "", "-format", "tsv", path("out.mmdb"))
+	if err != nil || !strings.Contains(out, "1.2.3.0\t1.2.3.255\t1.2.3.0/24\t中国\t北京") {
+		t.Fatalf("mmdb export got %q, %v", out, err)
+	}
+	if _, err := run(t, runBuild, "", "-o", path("from-mmdb.db"), "-mmdb-source", path("out.mmdb"), "-area", path("area.csv")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, err = run(t, runLookup, "", "-db", path("from-mmdb.db"), "1.2.3.4")
+	if err != nil || out != "1.2.3.4 中国|北京|北京市|联通|110000|11|0\n" {
+		t.Fatalf("lookup in the db built from the mmdb got %q, %v", out, err)
+	}
+
+	if _, err := run(t, runBuild, "", "-o", path("upstream.db"), "-merge", path("ip.merge.txt"), "-overlay", path("overlay.txt"), "-upstream"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, err = run(t, runLookup, "", "-db", path("upstream.db"), "-area", path("area.csv"), "-isp", path("isp.csv"), "1.2.3.4")
+	if err != nil || out != "1.2.3.4 中国|北京|北京市|联通|110000|11|2\n" {
+		t.Fatalf("upstream lookup got %q, %v", out, err)
+	}
+	if out, err = run(t, runInspect, "", path("upstream.db")); err != nil || !strings.Contains(out, "data layout:      upstream v1") {
+		t.Fatalf("upstream inspect got %q, %v", out, err)
+	}
+	out, err = run(t, runExport, "", "-format", "tsv", "-area", path("area.csv"), "-isp", path("isp.csv"), path("upstream.db"))
+	if err != nil || !strings.Contains(out, "\t中国\t北京\t北京市\t联通\t110000\t11\t2\n") {
+		t.Fatalf("upstream export got %q, %v", out, err)
+	}
+
+	out, err = run(t, runBuild, "", "-o", path("ip2region.xdb"), "-merge", path("ip.merge.txt"), "-merge", path("v6.txt"), "-overlay", path("overlay.txt"), "-xdb")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(out, "5 ipv4 ranges, 2 ipv6 ranges skipped") {
+		t.Fatalf("xdb build got %q", out)
+	}
+	out, err = run(t, runLookup, "", "-db", path("ip2region.xdb"), "-area", path("area.csv"), "-isp", path("isp.csv"), "1.2.3.4", "1.2.4.0")
+	if want := "1.2.3.4 中国|北京|北京市|联通|110000|11|2\n1.2.4.0 中国|广东|深圳市|电信|440000|44|3\n"; err != nil || out != want {
+		t.Fatalf("xdb lookup got %q, %v, want %q", out, err, want)
+	}
+	out, err = run(t, runExport, "", "-format", "tsv", path("ip2region.xdb"))
+	if err != nil || !strings.Contains(out, "1.0.0.0\t1.2.2.255\t") {
+		t.Fatalf("xdb export got %q, %v", out, err)
+	}
+	if out, err = run(t, runVerify, "", path("ip2region.xdb")); err != nil {
+		t.Fatalf("xdb verify got %q, %v", out, err)
+	}
+
+	out, err = run(t, runLookup, "", "-db", db, "1.2.3.4", "1.2.4.0", "2001:db8::1", "2001:db8:1::1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := "1.2.3.4 中国|北京|北京市|联通|110000|11|2\n" +
+		"1.2.4.0 中国|广东|深圳市|电信|440000|44|3\n" +
+		"2001:db8::1 中国|北京|北京市|联通|110000|11|2\n" +
+		"2001:db8:1::1 美国|0|0|0|0|0|0\n"
+	if out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+
+	region, err := ip2region.New(db)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	md := region.Metadata()
+	region.Close()
+	if md == nil || len(md.Sources) != 3 || md.Sources[0].Version != "20240501" || len(md.CodeTables) != 2 {
+		t.Fatalf("metadata %+v", md)
+	}
+
+	if _, err := run(t, runBuild, "", "-o", db, "-geolite2-locations", path("locations.csv"), "-geolite2-city", path("city.csv"),
+		"-geolite2-asn", path("asn.csv"), "-overlay", path("overlay.txt"), "-area", path("area.csv"), "-isp", path("isp.csv")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, err = run(t, runLookup, "", "-db", db, "1.0.0.1", "1.2.0.1", "1.2.3.4", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want = "1.0.0.1 中国|广东|深圳市|0|440000|44|0\n" +
+		"1.2.0.1 中国|广东|深圳市|电信|440000|44|3\n" +
+		"1.2.3.4 中国|北京|北京市|联通|110000|11|2\n" +
+		"10.0.0.1 0|0|0|0|0|0|0\n"
+	if out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-geolite2-city", path("short.txt")}, "short.txt: geolite2 header: no network column"},
+		{[]string{"-merge", path("bad.txt")}, "bad.txt: line 1"},
+		{[]string{"-merge", path("reverse.txt")}, "line 1: start ip 1.0.0.9 is after end ip"},
+		{[]string{"-merge", path("short.txt")}, "line 1: 3 fields"},
+		{[]string{"-merge", path("ip.merge.txt"), "-area", path("badarea.csv")}, "badarea.csv: code table line 1"},
+	} {
+		_, err := run(t, runBuild, "", append([]string{"-o", db}, c.args...)...)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.args, err, c.want)
+		}
+	}
+	if _, err := run(t, runBuild, "", "-merge", path("ip.merge.txt")); err != errUsage {
+		t.Fatalf("expected errUsage without -o, got %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	out, err := run(t, runExport, "", "-format", "tsv", testDB)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1+6 || !strings.HasPrefix(lines[2], "1.0.0.0\t1.0.0.255\t1.0.0.0/24\t中国\t北京\t北京市\t联通\t110000\t11\t2") {
+		t.Fatalf("got %q", lines)
+	}
+
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.jsonl")
+	if _, err := run(t, runExport, "", "-format", "jsonl", "-o", path, testDB); err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 6 {
+		t.Fatalf("%d lines in %s", n, b)
+	}
+	if _, err := run(t, runExport, "", "-format", "xml", testDB); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+func runVerify(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("verify", "db...\n\nreports every problem found in each db, exits 1 if any db is broken")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	broken := 0
+	for _, path := range fs.Args() {
+		err := ip2region.Verify(path)
+		var verr *ip2region.VerifyError
+		switch {
+		case err == nil:
+			fmt.Fprintf(stdout, "%s: ok\n", path)
+		case errors.As(err, &verr):
+			broken++
+			for _, p := range verr.Problems {
+				fmt.Fprintf(stdout, "%s: %s\n", path, p)
+			}
+		default:
+			broken++
+			fmt.Fprintf(stdout, "%s: %v\n", path, err)
+		}
+	}
+	if broken > 0 {
+		return fmt.Errorf("%d of %d db(s) failed verification", broken, fs.NArg())
+	}
+	return nil
+}
@@ -1,6 +1,6 @@
 module github.com/hokitlee/go-ip2region
 
-go 1.14
+go 1.18
 
 require (
 	github.com/yanyiwu/gojieba v1.1.2
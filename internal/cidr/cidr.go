@@ -0,0 +1,59 @@
+// Package cidr splits address ranges into CIDR prefixes for the query and
+// maker packages.
+package cidr
+
+import "net/netip"
+
+// Prefixes splits [start, end] into the smallest list of CIDR prefixes
+// covering it, in address order: each one is the largest aligned block
+// starting at the first address not yet covered. It returns nil if start
+// and end are of different families or end is before start.
+func Prefixes(start, end netip.Addr) []netip.Prefix {
+	if !start.IsValid() || start.BitLen() != end.BitLen() || end.Less(start) {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+	for {
+		bits := 0
+		for ; bits < start.BitLen(); bits++ {
+			p := netip.PrefixFrom(start, bits)
+			if p.Masked().Addr() == start && !end.Less(LastAddr(p)) {
+				break
+			}
+		}
+		p := netip.PrefixFrom(start, bits)
+		prefixes = append(prefixes, p)
+
+		last := LastAddr(p)
+		if last == end {
+			return prefixes
+		}
+		start = last.Next()
+	}
+}
+
+// LastAddr returns the highest address of p.
+func LastAddr(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr()
+	if a.Is4() {
+		b := a.As4()
+		setHostBits(b[:], p.Bits())
+		return netip.AddrFrom4(b)
+	}
+	b := a.As16()
+	setHostBits(b[:], p.Bits())
+	return netip.AddrFrom16(b)
+}
+
+func setHostBits(b []byte, bits int) {
+	for i := range b {
+		switch {
+		case bits >= (i+1)*8:
+		case bits <= i*8:
+			b[i] = 0xff
+		default:
+			b[i] |= 0xff >> uint(bits-i*8)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package cidr
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixes(t *testing.T) {
+	for _, c := range []struct {
+		start, end string
+		want       []string
+	}{
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"1.2.3.4", "1.2.3.4", []string{"1.2.3.4/32"}},
+		{"10.0.0.0", "10.0.1.255", []string{"10.0.0.0/23"}},
+		{"1.0.0.1", "1.0.0.6", []string{"1.0.0.1/32", "1.0.0.2/31", "1.0.0.4/31", "1.0.0.6/32"}},
+		{"192.168.0.255", "192.168.2.0", []string{"192.168.0.255/32", "192.168.1.0/24", "192.168.2.0/32"}},
+		{"255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+		{"2001:db8::", "2001:db8::ffff", []string{"2001:db8::/112"}},
+		{"2001:db8::1", "2001:db8::2", []string{"2001:db8::1/128", "2001:db8::2/128"}},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+		{"1.2.3.4", "1.2.3.3", nil},
+	} {
+		var got []string
+		for _, p := range Prefixes(netip.MustParseAddr(c.start), netip.MustParseAddr(c.end)) {
+			got = append(got, p.String())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s-%s: got %v, want %v", c.start, c.end, got, c.want)
+		}
+	}
+}
+
+func TestLastAddr(t *testing.T) {
+	for in, want := range map[string]string{
+		"1.2.3.4/32":    "1.2.3.4",
+		"10.0.0.0/23":   "10.0.1.255",
+		"10.0.0.7/23":   "10.0.1.255",
+		"0.0.0.0/0":     "255.255.255.255",
+		"2001:db8::/32": "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff",
+	} {
+		if got := LastAddr(netip.MustParsePrefix(in)); got.String() != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
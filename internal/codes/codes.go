@@ -0,0 +1,56 @@
+// Package codes reads the area and isp code tables of the data directory
+// for the query and maker packages, and finds province names in them.
+package codes
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ProvinceSuffixes are stripped from province names missing from the area
+// code table, which names 广东省 as 广东. Longer suffixes come first.
+var ProvinceSuffixes = []string{"维吾尔自治区", "壮族自治区", "回族自治区", "特别行政区", "自治区", "省", "市"}
+
+// ReadTable calls fn with the two codes and the name of every line of a
+// code table, blank lines are skipped.
+func ReadTable(r io.Reader, fn func(a, b int64, name string)) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		ss := strings.Split(text, ",")
+		if len(ss) != 3 {
+			return fmt.Errorf("code table line %d: %d fields, want 3: %q", line, len(ss), text)
+		}
+		a, err := strconv.ParseInt(ss[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("code table line %d: %s", line, err)
+		}
+		b, err := strconv.ParseInt(ss[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("code table line %d: %s", line, err)
+		}
+		fn(a, b, ss[2])
+	}
+	return scanner.Err()
+}
+
+// Province returns the name the area code table uses for province: itself
+// if known reports it, else the first known name left after stripping one
+// of ProvinceSuffixes, else province unchanged.
+func Province(province string, known func(name string) bool) string {
+	if known(province) {
+		return province
+	}
+	for _, suffix := range ProvinceSuffixes {
+		if name := strings.TrimSuffix(province, suffix); name != province && known(name) {
+			return name
+		}
+	}
+	return province
+}
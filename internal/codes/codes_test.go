@@ -0,0 +1,46 @@
+package codes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadTable(t *testing.T) {
+	var lines []string
+	err := ReadTable(strings.NewReader("1,11,北京\n\n440000,44,广东\n"), func(a, b int64, name string) {
+		lines = append(lines, fmt.Sprintf("%d %d %s", a, b, name))
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if strings.Join(lines, ",") != "1 11 北京,440000 44 广东" {
+		t.Fatalf("got %v", lines)
+	}
+
+	for in, want := range map[string]string{
+		"1,11\n":            "code table line 1: 2 fields",
+		"1,11,北京\nx,1,天津\n": "code table line 2",
+	} {
+		if err := ReadTable(strings.NewReader(in), func(int64, int64, string) {}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", in, err, want)
+		}
+	}
+}
+
+func TestProvince(t *testing.T) {
+	known := func(name string) bool {
+		return name == "广东" || name == "新疆" || name == "北京"
+	}
+	for in, want := range map[string]string{
+		"广东省":      "广东",
+		"新疆维吾尔自治区": "新疆",
+		"北京":       "北京",
+		"北京市":      "北京",
+		"江苏省":      "江苏省",
+	} {
+		if got := Province(in, known); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
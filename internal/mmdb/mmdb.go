@@ -0,0 +1,31 @@
+// Package mmdb holds the MaxMind DB format constants shared by the reader
+// of the query package and the writer of the maker package, see
+// https://maxmind.github.io/MaxMind-DB/.
+package mmdb
+
+const (
+	// DataSeparator is the number of zero bytes between the search tree
+	// and the data section.
+	DataSeparator = 16
+	// MetadataStart marks the metadata map at the end of a file.
+	MetadataStart = "\xAB\xCD\xEFMaxMind.com"
+)
+
+// data types of the data section
+const (
+	Pointer   = 1
+	String    = 2
+	Double    = 3
+	Bytes     = 4
+	Uint16    = 5
+	Uint32    = 6
+	Map       = 7
+	Int32     = 8
+	Uint64    = 9
+	Uint128   = 10
+	Array     = 11
+	Container = 12
+	EndMarker = 13
+	Bool      = 14
+	Float     = 15
+)
@@ -0,0 +1,35 @@
+package maker
+
+import (
+	"io"
+
+	"github.com/hokitlee/go-ip2region/internal/codes"
+)
+
+// ReadAreaCodes reads a code table in the layout of data/area_code.csv,
+// "regionId,provinceId,name" per line, into the region and province code
+// maps taken by NewMaker.
+func ReadAreaCodes(r io.Reader) (rm, pm map[string]int, err error) {
+	rm, pm = make(map[string]int), make(map[string]int)
+	err = codes.ReadTable(r, func(a, b int64, name string) {
+		rm[name] = int(a)
+		pm[name] = int(b)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return rm, pm, nil
+}
+
+// ReadISPCodes reads a code table in the layout of data/isp_code.csv,
+// "code,ispId,name" per line, into the isp code map taken by NewMaker.
+func ReadISPCodes(r io.Reader) (map[string]int, error) {
+	im := make(map[string]int)
+	err := codes.ReadTable(r, func(_, b int64, name string) {
+		im[name] = int(b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return im, nil
+}
@@ -0,0 +1,78 @@
+package maker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+// The META section is a JSON object describing the build, the
+// ip2region.Metadata read back by the query package. It is written after
+// every other section and its checksum covers all bytes before it.
+const (
+	SectionMetadata = ip2region.SectionMetadata
+
+	// FormatVersion is the db format version recorded in the META section.
+	FormatVersion = ip2region.FormatVersion
+
+	// DataLayoutUpstream is the data_layout of dbs written with
+	// WithUpstreamLayout.
+	DataLayoutUpstream = ip2region.DataLayoutUpstream
+)
+
+// Source names a dataset or code table and its version.
+type Source = ip2region.Source
+
+// WithSource records a source dataset of the db in its META section.
+func WithSource(name, version string) Option {
+	return func(mk *Maker) {
+		mk.sources = append(mk.sources, Source{Name: name, Version: version})
+	}
+}
+
+// WithCodeTable records a code table used for the ids in the META section.
+func WithCodeTable(name, version string) Option {
+	return func(mk *Maker) {
+		mk.codeTables = append(mk.codeTables, Source{Name: name, Version: version})
+	}
+}
+
+// WithBuildTime sets the build time of the META section instead of the
+// time of the build, so that identical inputs produce identical dbs.
+func WithBuildTime(t time.Time) Option {
+	return func(mk *Maker) {
+		mk.buildTime = t
+	}
+}
+
+// writeMetadata writes the META section at end, the current end of the
+// db file.
+func (mk *Maker) writeMetadata(end int64) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(mk.dbFile, 0, end)); err != nil {
+		return err
+	}
+
+	info := ip2region.Metadata{
+		FormatVersion: FormatVersion,
+		BuildTime:     mk.buildTime.UTC(),
+		Sources:       mk.sources,
+		CodeTables:    mk.codeTables,
+		IPv4Ranges:    len(mk.indexPool),
+		IPv6Ranges:    len(mk.index6Pool),
+		DataBlocks:    len(mk.regionRecordMap),
+		Checksum:      "sha256:" + hex.EncodeToString(h.Sum(nil)),
+	}
+	if mk.upstream {
+		info.DataLayout = DataLayoutUpstream
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return mk.writeSection(SectionMetadata, b)
+}
@@ -0,0 +1,272 @@
+package maker
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/hokitlee/go-ip2region/internal/cidr"
+	"github.com/hokitlee/go-ip2region/internal/codes"
+)
+
+// ISPKeyword maps autonomous system organizations containing Keyword,
+// compared case insensitively, to the isp name ISP of the isp code table.
+type ISPKeyword struct {
+	Keyword string
+	ISP     string
+}
+
+// DefaultISPKeywords recognises the Chinese carriers in the GeoLite2 ASN
+// organizations.
+var DefaultISPKeywords = []ISPKeyword{
+	{"chinanet", "电信"},
+	{"china telecom", "电信"},
+	{"unicom", "联通"},
+	{"china169", "联通"},
+	{"china mobile", "移动"},
+	{"cmnet", "移动"},
+	{"tietong", "铁通"},
+	{"railcom", "铁通"},
+}
+
+// GeoLite2 converts the MaxMind GeoLite2 City and ASN CSV databases to
+// Metadata. Read the locations file before the city blocks files, the
+// blocks files of both families and the ASN blocks files can be read in
+// any order.
+type GeoLite2 struct {
+	// ProvinceCodes is the province code map given to NewMaker, Chinese
+	// subdivision names are stored as the province they name in it, so
+	// "广东省" becomes "广东".
+	ProvinceCodes map[string]int
+	// ISPKeywords maps the ASN organizations to isp names, other
+	// organizations are stored as they are. DefaultISPKeywords when nil.
+	ISPKeywords []ISPKeyword
+
+	locations map[string]geoLocation
+	city      []geoRange
+	asn       []geoRange
+}
+
+type geoLocation struct {
+	country  string
+	province string
+	city     string
+}
+
+type geoRange struct {
+	start, end netip.Addr
+	loc        geoLocation
+	isp        string
+}
+
+// ReadLocations reads a GeoLite2-City-Locations-<locale>.csv file.
+func (g *GeoLite2) ReadLocations(r io.Reader) error {
+	if g.locations == nil {
+		g.locations = make(map[string]geoLocation)
+	}
+	return readGeoCSV(r, []string{"geoname_id", "country_name", "subdivision_1_name", "city_name"}, func(f []string) error {
+		g.locations[f[0]] = geoLocation{
+			country:  f[1],
+			province: g.province(f[2]),
+			city:     f[3],
+		}
+		return nil
+	})
+}
+
+func (g *GeoLite2) province(name string) string {
+	if g.ProvinceCodes == nil || name == "" {
+		return name
+	}
+	return codes.Province(name, func(name string) bool {
+		_, ok := g.ProvinceCodes[name]
+		return ok
+	})
+}
+
+// ReadCityBlocks reads a GeoLite2-City-Blocks-IPv4.csv or -IPv6.csv file.
+// Networks without a location of their own get the one of their
+// registered country.
+func (g *GeoLite2) ReadCityBlocks(r io.Reader) error {
+	return readGeoCSV(r, []string{"network", "geoname_id", "registered_country_geoname_id"}, func(f []string) error {
+		start, end, ok, err := networkRange(f[0])
+		if err != nil || !ok {
+			return err
+		}
+		id := f[1]
+		if id == "" {
+			id = f[2]
+		}
+		g.city = append(g.city, geoRange{start: start, end: end, loc: g.locations[id]})
+		return nil
+	})
+}
+
+// ReadASNBlocks reads a GeoLite2-ASN-Blocks-IPv4.csv or -IPv6.csv file.
+func (g *GeoLite2) ReadASNBlocks(r io.Reader) error {
+	return readGeoCSV(r, []string{"network", "autonomous_system_organization"}, func(f []string) error {
+		start, end, ok, err := networkRange(f[0])
+		if err != nil || !ok {
+			return err
+		}
+		g.asn = append(g.asn, geoRange{start: start, end: end, isp: g.isp(f[1])})
+		return nil
+	})
+}
+
+func (g *GeoLite2) isp(org string) string {
+	keywords := g.ISPKeywords
+	if keywords == nil {
+		keywords = DefaultISPKeywords
+	}
+	lower := strings.ToLower(org)
+	for _, k := range keywords {
+		if strings.Contains(lower, strings.ToLower(k.Keyword)) {
+			return k.ISP
+		}
+	}
+	return org
+}
+
+// Metadata returns the ranges read so far with the isp of the ASN blocks
+// they overlap, sorted by start ip, the IPv4 ranges first. Adjacent ranges
+// of the same region are joined and the gaps between IPv4 ranges are
+// filled with unknown ranges, so that the IPv4 part covers the whole
+// address space like ip.merge.txt. MergeMetadata merges the IPv4 and the
+// IPv6 parts separately, so the result can be merged with it as is.
+func (g *GeoLite2) Metadata() []Metadata {
+	city := g.city
+	if len(city) == 0 {
+		// only ASN blocks were read
+		city = g.asn
+	}
+	sortGeoRanges(city)
+	sortGeoRanges(g.asn)
+
+	var ranges []geoRange
+	emit := func(start, end netip.Addr, loc geoLocation, isp string) {
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if last.loc == loc && last.isp == isp && last.end.Next() == start {
+				last.end = end
+				return
+			}
+		}
+		ranges = append(ranges, geoRange{start: start, end: end, loc: loc, isp: isp})
+	}
+
+	j := 0
+	for _, c := range city {
+		start := c.start
+		for {
+			for j < len(g.asn) && g.asn[j].end.Less(start) {
+				j++
+			}
+			if j == len(g.asn) || c.end.Less(g.asn[j].start) {
+				emit(start, c.end, c.loc, c.isp)
+				break
+			}
+			a := g.asn[j]
+			if start.Less(a.start) {
+				emit(start, a.start.Prev(), c.loc, c.isp)
+				start = a.start
+			}
+			end := c.end
+			if a.end.Less(end) {
+				end = a.end
+			}
+			emit(start, end, c.loc, a.isp)
+			if end == c.end {
+				break
+			}
+			start = end.Next()
+		}
+	}
+
+	mds := make([]Metadata, 0, len(ranges))
+	for _, r := range ranges {
+		md := Metadata{
+			StartIP:  r.start.String(),
+			EndIP:    r.end.String(),
+			Country:  r.loc.country,
+			Province: r.loc.province,
+			City:     r.loc.city,
+			Isp:      r.isp,
+		}
+		md.Format()
+		mds = append(mds, md)
+	}
+	return fillIPv4Gaps(mds)
+}
+
+func sortGeoRanges(rs []geoRange) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		a, b := rs[i].start, rs[j].start
+		if a.Is4() != b.Is4() {
+			return a.Is4()
+		}
+		return a.Less(b)
+	})
+}
+
+// networkRange returns the first and last address of a CIDR network.
+// IPv4-mapped IPv6 networks duplicate IPv4 ones and are skipped.
+func networkRange(network string) (start, end netip.Addr, ok bool, err error) {
+	p, err := netip.ParsePrefix(network)
+	if err != nil {
+		return start, end, false, err
+	}
+	p = p.Masked()
+	start = p.Addr()
+	if start.Is4In6() {
+		return start, end, false, nil
+	}
+	return start, cidr.LastAddr(p), true, nil
+}
+
+// readGeoCSV calls fn with the named columns of every row of a GeoLite2
+// CSV file, errors carry the line number.
+func readGeoCSV(r io.Reader, columns []string, fn func(fields []string) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("geolite2 header: %w", err)
+	}
+	idx := make([]int, len(columns))
+	for i, c := range columns {
+		idx[i] = -1
+		for k, h := range header {
+			if strings.TrimPrefix(h, "\ufeff") == c {
+				idx[i] = k
+			}
+		}
+		if idx[i] < 0 {
+			return fmt.Errorf("geolite2 header: no %s column", c)
+		}
+	}
+
+	fields := make([]string, len(columns))
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		for i, k := range idx {
+			if k >= len(row) {
+				return fmt.Errorf("geolite2 line %d: %d fields", line, len(row))
+			}
+			fields[i] = row[k]
+		}
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("geolite2 line %d: %w", line, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package maker
 
 import (
-	"errors"
+	"net"
 	"strconv"
 	"strings"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
 )
 
 func WriteIntLong(b []byte, offset int, v int64) {
@@ -16,19 +18,32 @@ func WriteIntLong(b []byte, offset int, v int64) {
 	b[offset] = byte((v >> 24) & 0xFF)
 }
 
+// ParseError is returned for a string that is not a valid ip address, it
+// is the ParseError of the query package so that both parse alike.
+type ParseError = ip2region.ParseError
+
+// IpString2Int64 parses a strict dotted-quad address: four decimal numbers
+// of at most 255 without signs, spaces or leading zeros.
 func IpString2Int64(IpStr string) (int64, error) {
-	bits := strings.Split(IpStr, ".")
-	if len(bits) != 4 {
-		return 0, errors.New("ip format error")
-	}
+	return ip2region.Ip2long(IpStr)
+}
 
-	var sum int64
-	for i, n := range bits {
-		bit, _ := strconv.ParseInt(n, 10, 64)
-		sum += bit << uint(24-8*i)
+// IsIPv6 reports whether IpStr is an IPv6 address that has no IPv4 form.
+func IsIPv6(IpStr string) bool {
+	if strings.IndexByte(IpStr, ':') < 0 {
+		return false
 	}
+	ip := net.ParseIP(IpStr)
+	return ip != nil && ip.To4() == nil
+}
 
-	return sum, nil
+// IpString2IPv6 parses an IPv6 address into its 16 byte form.
+func IpString2IPv6(IpStr string) (net.IP, error) {
+	ip := net.ParseIP(IpStr)
+	if ip == nil || ip.To4() != nil {
+		return nil, &ParseError{Input: IpStr, Msg: "not an ipv6 address"}
+	}
+	return ip, nil
 }
 
 func IpInt642String(n int64) string {
@@ -43,18 +58,7 @@ func IpInt642String(n int64) string {
 }
 
 func Ip2long(IpStr string) (int64, error) {
-	bits := strings.Split(IpStr, ".")
-	if len(bits) != 4 {
-		return 0, errors.New("ip format error")
-	}
-
-	var sum int64
-	for i, n := range bits {
-		bit, _ := strconv.ParseInt(n, 10, 64)
-		sum += bit << uint(24-8*i)
-	}
-
-	return sum, nil
+	return IpString2Int64(IpStr)
 }
 
 func IpLong2String(n int64) string {
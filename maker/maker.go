@@ -4,10 +4,15 @@ import (
 	"container/list"
 	"io"
 	"log"
+	"net"
+	"net/netip"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
 )
 
 /**
@@ -38,9 +43,35 @@ import (
  * | 4bytes		| 4bytes	| 4bytes		|
  * +------------+-----------+---------------+
  * start ip 	  end ip	  3 byte data ptr & 1 byte data length
+ * <p>
+ * 4. optional sections, each one:
+ * +------------+-----------+-----------------------+
+ * | 4bytes		| 4bytes	| dynamic length		|
+ * +------------+-----------+-----------------------+
+ * tag		  length	  payload
+ * <p>
+ * VECT: 256*256 vector index, first & last index ptr of every /16
+ * IPV6: ipv6 index blocks sorted by start ip, sharing the data part
+ * +------------+-----------+---------------+
+ * | 16bytes	| 16bytes	| 4bytes		|
+ * +------------+-----------+---------------+
+ * start ip 	  end ip	  3 byte data ptr & 1 byte data length
+ * META: json build metadata, always the last section
  *
  */
 
+// the sections are defined by the query package, which reads them
+const (
+	// SectionHeaderLength is the size of the tag and length of a section.
+	SectionHeaderLength = ip2region.SectionHeaderLength
+
+	SectionVectorIndex = ip2region.SectionVectorIndex
+	SectionIPv6Index   = ip2region.SectionIPv6Index
+	IndexBlock6Length  = ip2region.IndexBlock6Length
+	VectorIndexLength  = ip2region.VectorIndexLength
+	VectorIndexSize    = ip2region.VectorIndexSize
+)
+
 type DateBlock struct {
 	country    string
 	province   string
@@ -81,6 +112,29 @@ func (dbl *DateBlock) String() string {
 	return strings.Join(s, "|")
 }
 
+// upstreamBytes returns the block in the upstream v1 layout, a little
+// endian city id and the upstream region. There are no city ids in
+// Metadata, so it is written as 0.
+func (dbl *DateBlock) upstreamBytes() []byte {
+	cityId := make([]byte, 4)
+	return append(cityId, dbl.upstreamRegion()...)
+}
+
+// upstreamRegion returns the block as an upstream region,
+// 国家|区域|省份|城市|ISP. There is no 区域 in Metadata, so it is written
+// as 0.
+func (dbl *DateBlock) upstreamRegion() string {
+	s := []string{dbl.country, "0", dbl.province, dbl.city, dbl.isp}
+	for i := range s {
+		if s[i] == "" {
+			s[i] = "0"
+		}
+	}
+	return strings.Join(s, "|")
+}
+
+// Metadata is one ip range of the source data, StartIP and EndIP are
+// either both IPv4 or both IPv6 addresses.
 type Metadata struct {
 	StartIP  string
 	EndIP    string
@@ -145,6 +199,23 @@ func (ib *IndexBlock) getBytes() []byte {
 	return b
 }
 
+type IndexBlock6 struct {
+	//36
+	startIP net.IP
+	endIP   net.IP
+	dataPtr int
+	dataLen int
+}
+
+func (ib *IndexBlock6) getBytes() []byte {
+	b := make([]byte, IndexBlock6Length)
+	copy(b, ib.startIP)
+	copy(b[16:], ib.endIP)
+	mix := ib.dataPtr | ((ib.dataLen << 24) & 0xFF000000)
+	WriteIntLong(b, 32, int64(mix))
+	return b
+}
+
 type HeaderBlock struct {
 
 	/**
@@ -182,6 +253,8 @@ type Maker struct {
 
 	indexPool []IndexBlock
 
+	index6Pool []IndexBlock6
+
 	headerBlockPool []HeaderBlock
 
 	metadata []Metadata
@@ -193,9 +266,46 @@ type Maker struct {
 	ispCodeMap map[string]int
 
 	regionRecordMap map[string]IndexBlock
+
+	vectorIndex bool
+
+	// data blocks in the upstream v1 layout
+	upstream bool
+
+	// an upstream xdb file is written instead of a db
+	xdb bool
+
+	// also written as an MMDB file when set
+	mmdbPath string
+
+	// recorded in the META section
+	sources    []Source
+	codeTables []Source
+	buildTime  time.Time
+}
+
+// Option configures optional parts of the db written by a Maker.
+type Option func(*Maker)
+
+// WithVectorIndex makes the Maker write a vector index section, which lets
+// searchers jump straight to the index blocks of an ip's /16.
+func WithVectorIndex() Option {
+	return func(mk *Maker) {
+		mk.vectorIndex = true
+	}
 }
 
-func NewMaker(dbFilePath string, md []Metadata, rm, pm, im map[string]int) *Maker {
+// WithUpstreamLayout makes the Maker write the data blocks in the layout
+// of upstream lionsoul ip2region v1 dbs, which upstream searchers can read.
+// Upstream dbs carry no ids, searchers of this package look them up in the
+// code tables given to them instead.
+func WithUpstreamLayout() Option {
+	return func(mk *Maker) {
+		mk.upstream = true
+	}
+}
+
+func NewMaker(dbFilePath string, md []Metadata, rm, pm, im map[string]int, opts ...Option) *Maker {
 	if rm == nil {
 		rm = make(map[string]int)
 	}
@@ -220,19 +330,42 @@ func NewMaker(dbFilePath string, md []Metadata, rm, pm, im map[string]int) *Make
 		ispCodeMap:       im,
 		regionRecordMap:  make(map[string]IndexBlock),
 	}
+	for _, opt := range opts {
+		opt(mk)
+	}
 
 	return mk
 }
 
-func (mk *Maker) make(extra ...Metadata) error {
+// Make writes the db to the path given to NewMaker, replacing any file
+// there. IPv4 ranges must be sorted by start ip, as must IPv6 ranges;
+// extra ranges are merged over them with MergeMetadataErr first.
+func (mk *Maker) Make(extra ...Metadata) error {
 
 	if len(extra) != 0 {
 		log.Printf("has extra ip recod \n")
-		mk.metadata = MergeMetadata(mk.metadata, extra)
+		var err error
+		mk.metadata, err = MergeMetadataErr(mk.metadata, extra)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mk.buildTime.IsZero() {
+		mk.buildTime = time.Now()
+	}
+
+	if mk.xdb {
+		log.Println("+-Try to write the xdb ... ")
+		if err := mk.writeXdbFile(mk.dbFilePath); err != nil {
+			return err
+		}
+		log.Println("|--[Ok]")
+		return mk.makeMMDB()
 	}
 
 	var err error
-	mk.dbFile, err = os.OpenFile(mk.dbFilePath, os.O_RDWR|os.O_CREATE, 0755)
+	mk.dbFile, err = os.OpenFile(mk.dbFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
 		return err
 	}
@@ -248,6 +381,14 @@ func (mk *Maker) make(extra ...Metadata) error {
 
 	log.Println("+-Try to write the data blocks")
 	for _, n := range mk.metadata {
+		if IsIPv6(n.StartIP) {
+			ib, err := mk.addDataBlock6(n)
+			if err != nil {
+				return err
+			}
+			mk.index6Pool = append(mk.index6Pool, *ib)
+			continue
+		}
 		ib, err := mk.addDataBlock(n)
 		if err != nil {
 			return err
@@ -258,17 +399,18 @@ func (mk *Maker) make(extra ...Metadata) error {
 	log.Println("|--[Ok]")
 	log.Println("+-Try to write index blocks ... ")
 
-	startIpN := mk.indexPool[0].startIP
 	indexStartPrt, err := mk.dbFile.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return err
 	}
 
 	log.Printf("+- index start pointer %d \n", indexStartPrt)
-	mk.headerBlockPool = append(mk.headerBlockPool, HeaderBlock{
-		indexStartIp: startIpN,
-		indexPtr:     int(indexStartPrt),
-	})
+	if len(mk.indexPool) > 0 {
+		mk.headerBlockPool = append(mk.headerBlockPool, HeaderBlock{
+			indexStartIp: mk.indexPool[0].startIP,
+			indexPtr:     int(indexStartPrt),
+		})
+	}
 
 	blockLength := mk.indexBlockLength
 	counter, shotCounter := 0, mk.indexBlockSize/blockLength-1
@@ -307,14 +449,34 @@ func (mk *Maker) make(extra ...Metadata) error {
 
 	log.Println("|--[Ok]")
 
-	log.Println("+-Try to write the super blocks ... ")
 	indexEndPrt, err := mk.dbFile.Seek(0, io.SeekCurrent)
-	indexEndPrt -= int64(blockLength)
-	log.Printf("+- index end pointer %d \n", indexEndPrt)
-
 	if err != nil {
 		return err
 	}
+	indexEndPrt -= int64(blockLength)
+
+	if mk.vectorIndex {
+		log.Println("+-Try to write the vector index ... ")
+		if err := mk.writeSection(SectionVectorIndex, mk.vectorIndexBytes(indexStartPrt)); err != nil {
+			return err
+		}
+		log.Println("|--[Ok]")
+	}
+
+	if len(mk.index6Pool) > 0 {
+		log.Println("+-Try to write the ipv6 index blocks ... ")
+		b := make([]byte, 0, len(mk.index6Pool)*IndexBlock6Length)
+		for _, n := range mk.index6Pool {
+			b = append(b, n.getBytes()...)
+		}
+		if err := mk.writeSection(SectionIPv6Index, b); err != nil {
+			return err
+		}
+		log.Println("|--[Ok]")
+	}
+
+	log.Println("+-Try to write the super blocks ... ")
+	log.Printf("+- index end pointer %d \n", indexEndPrt)
 
 	_, err = mk.dbFile.Seek(0, io.SeekStart)
 	if err != nil {
@@ -337,14 +499,49 @@ func (mk *Maker) make(extra ...Metadata) error {
 			return err
 		}
 	}
-	_, err = mk.dbFile.Seek(0, io.SeekEnd)
+	end, err := mk.dbFile.Seek(0, io.SeekEnd)
 	if err != nil {
 		return err
 	}
 	log.Println("|--[Ok]")
-	mk.dbFile.Write([]byte("Created by PPIO at " + time.Now().String()))
+
+	log.Println("+-Try to write the metadata ... ")
+	if err := mk.writeMetadata(end); err != nil {
+		return err
+	}
+	log.Println("|--[Ok]")
+	mk.dbFile.Write([]byte("Created by PPIO at " + mk.buildTime.String()))
 	log.Println("make db finish")
-	return nil
+
+	return mk.makeMMDB()
+}
+
+// vectorIndexBytes records the first and last index block of every /16,
+// indexStartPrt is the pointer of the first index block.
+func (mk *Maker) vectorIndexBytes(indexStartPrt int64) []byte {
+	b := make([]byte, VectorIndexSize)
+	for i, n := range mk.indexPool {
+		ptr := indexStartPrt + int64(i*mk.indexBlockLength)
+		for k := int(n.startIP >> 16); k <= int(n.endIP>>16); k++ {
+			p := k * VectorIndexLength
+			if b[p] == 0 && b[p+1] == 0 && b[p+2] == 0 && b[p+3] == 0 {
+				WriteIntLong(b, p, ptr)
+			}
+			WriteIntLong(b, p+4, ptr)
+		}
+	}
+	return b
+}
+
+func (mk *Maker) writeSection(tag string, payload []byte) error {
+	b := make([]byte, SectionHeaderLength)
+	copy(b, tag)
+	WriteIntLong(b, 4, int64(len(payload)))
+	if _, err := mk.dbFile.Write(b); err != nil {
+		return err
+	}
+	_, err := mk.dbFile.Write(payload)
+	return err
 }
 
 func (mk *Maker) initDBFile() error {
@@ -373,11 +570,41 @@ func (mk *Maker) addDataBlock(md Metadata) (*IndexBlock, error) {
 		return nil, err
 	}
 
-	if ib, ok := mk.regionRecordMap[md.RegionString()]; ok {
+	ib, err := mk.writeDataBlock(md)
+	if err != nil {
+		return nil, err
+	}
+	ib.startIP = sIpN
+	ib.endIP = eIpN
+	return ib, nil
+}
 
-		ib.startIP = sIpN
-		ib.endIP = eIpN
+func (mk *Maker) addDataBlock6(md Metadata) (*IndexBlock6, error) {
+	sIp, err := IpString2IPv6(md.StartIP)
+	if err != nil {
+		return nil, err
+	}
+	eIp, err := IpString2IPv6(md.EndIP)
+	if err != nil {
+		return nil, err
+	}
 
+	ib, err := mk.writeDataBlock(md)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexBlock6{
+		startIP: sIp,
+		endIP:   eIp,
+		dataPtr: ib.dataPtr,
+		dataLen: ib.dataLen,
+	}, nil
+}
+
+// writeDataBlock writes the data block of md's region unless an earlier
+// range already did, and returns an index block pointing to it.
+func (mk *Maker) writeDataBlock(md Metadata) (*IndexBlock, error) {
+	if ib, ok := mk.regionRecordMap[md.RegionString()]; ok {
 		return &ib, nil
 	}
 
@@ -386,12 +613,11 @@ func (mk *Maker) addDataBlock(md Metadata) (*IndexBlock, error) {
 		return nil, err
 	}
 
-	dataBlock := md.toDateBlock()
-	dataBlock.regionId = mk.regionCodeMap[dataBlock.province]
-	dataBlock.provinceId = mk.provinceCodeMap[dataBlock.province]
-	dataBlock.ispId = mk.ispCodeMap[dataBlock.isp]
-
+	dataBlock := mk.dataBlock(md)
 	dataBytes := dataBlock.Bytes()
+	if mk.upstream {
+		dataBytes = dataBlock.upstreamBytes()
+	}
 
 	dataLen, err := mk.dbFile.Write(dataBytes)
 	if err != nil {
@@ -399,8 +625,6 @@ func (mk *Maker) addDataBlock(md Metadata) (*IndexBlock, error) {
 	}
 
 	ib := IndexBlock{
-		startIP: sIpN,
-		endIP:   eIpN,
 		dataPtr: int(prt),
 		dataLen: dataLen,
 	}
@@ -409,11 +633,78 @@ func (mk *Maker) addDataBlock(md Metadata) (*IndexBlock, error) {
 	return &ib, err
 }
 
-// weight of m more then n
+// dataBlock returns md's region with the ids of the code tables.
+func (mk *Maker) dataBlock(md Metadata) DateBlock {
+	dataBlock := md.toDateBlock()
+	dataBlock.regionId = mk.regionCodeMap[dataBlock.province]
+	dataBlock.provinceId = mk.provinceCodeMap[dataBlock.province]
+	dataBlock.ispId = mk.ispCodeMap[dataBlock.isp]
+	return dataBlock
+}
+
+// MergeMetadata lays the ranges of m over those of n, m weighs more. The
+// IPv4 and the IPv6 ranges, each sorted by start ip, are merged separately
+// and the merged IPv4 ranges come first. Invalid ranges are logged and
+// left out, MergeMetadataErr returns them as an error instead.
 func MergeMetadata(n, m []Metadata) []Metadata {
+	res, err := MergeMetadataErr(validMetadata(n), validMetadata(m))
+	if err != nil {
+		// not reached, the ranges have been checked
+		log.Printf("MergeMetadata: %s \n", err)
+		return n
+	}
+	return res
+}
+
+// validMetadata returns the ranges of mds that checkRange accepts, logging
+// the others.
+func validMetadata(mds []Metadata) []Metadata {
+	valid := make([]Metadata, 0, len(mds))
+	for _, md := range mds {
+		if err := checkRange(md.StartIP, md.EndIP); err != nil {
+			log.Printf("+- skip invalid range %s: %s \n", md.String(), err)
+			continue
+		}
+		valid = append(valid, md)
+	}
+	return valid
+}
+
+// MergeMetadataErr is MergeMetadata returning an error for an invalid ip.
+func MergeMetadataErr(n, m []Metadata) ([]Metadata, error) {
 
 	log.Printf("MergeMetadata, old dataSize %d, extra dataSize %d \n", len(n), len(m))
 
+	n4, n6 := splitFamilies(n)
+	m4, m6 := splitFamilies(m)
+	res, err := mergeMetadata4(n4, m4)
+	if err != nil {
+		return nil, err
+	}
+	res6, err := mergeMetadata6(n6, m6)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, res6...)
+
+	log.Printf("MergeMetadata finish, dataSize %d \n", len(res))
+
+	return res, nil
+}
+
+// splitFamilies returns the IPv4 and the IPv6 ranges of mds.
+func splitFamilies(mds []Metadata) (v4, v6 []Metadata) {
+	for _, md := range mds {
+		if IsIPv6(md.StartIP) {
+			v6 = append(v6, md)
+		} else {
+			v4 = append(v4, md)
+		}
+	}
+	return v4, v6
+}
+
+func mergeMetadata4(n, m []Metadata) ([]Metadata, error) {
 	mi := 0
 
 	ls := list.New()
@@ -424,28 +715,55 @@ func MergeMetadata(n, m []Metadata) []Metadata {
 		EI int64
 	}
 
-	cover2Data := func(m Metadata) Data {
-		si, _ := Ip2long(m.StartIP)
-		ei, _ := Ip2long(m.EndIP)
+	cover2Data := func(m Metadata) (Data, error) {
+		si, err := Ip2long(m.StartIP)
+		if err != nil {
+			return Data{}, err
+		}
+		ei, err := Ip2long(m.EndIP)
+		if err != nil {
+			return Data{}, err
+		}
 		return Data{
 			Metadata: m,
 			SI:       si,
 			EI:       ei,
-		}
+		}, nil
 	}
 
 	for _, n := range n {
-		ls.PushBack(cover2Data(n))
+		d, err := cover2Data(n)
+		if err != nil {
+			return nil, err
+		}
+		ls.PushBack(d)
+	}
+	extra := make([]Data, 0, len(m))
+	for _, m := range m {
+		d, err := cover2Data(m)
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, d)
 	}
 
 	curr := ls.Front()
 
+	// insert before curr, which is nil once the end of the list was removed
+	insert := func(d Data) {
+		if curr == nil {
+			ls.PushBack(d)
+		} else {
+			ls.InsertBefore(d, curr)
+		}
+	}
+
 	for {
-		if mi >= len(m) || curr == nil {
+		if mi >= len(extra) || curr == nil {
 			break
 		}
 		v := curr.Value.(Data)
-		cm := cover2Data(m[mi])
+		cm := extra[mi]
 		if cm.SI > v.EI {
 			curr = curr.Next()
 			continue
@@ -469,16 +787,16 @@ func MergeMetadata(n, m []Metadata) []Metadata {
 			d := av
 			d.EI = cm.SI - 1
 			d.EndIP = IpLong2String(d.EI)
-			ls.InsertBefore(d, curr)
+			insert(d)
 		}
 
-		ls.InsertBefore(cm, curr)
+		insert(cm)
 
 		if cm.EI != bv.EI {
 			d := bv
 			d.SI = cm.EI + 1
 			d.StartIP = IpLong2String(d.SI)
-			ls.InsertBefore(d, curr)
+			insert(d)
 		}
 
 		mi++
@@ -489,8 +807,76 @@ func MergeMetadata(n, m []Metadata) []Metadata {
 	for f := ls.Front(); f != nil; f = f.Next() {
 		res = append(res, f.Value.(Data).Metadata)
 	}
+	return res, nil
+}
 
-	log.Printf("MergeMetadata finish, dataSize %d \n", len(res))
+// mergeMetadata6 lays the IPv6 ranges of m over those of n. Unlike the
+// IPv4 ranges they need not cover the address space, the parts of n not
+// covered by m are kept.
+func mergeMetadata6(n, m []Metadata) ([]Metadata, error) {
+	if len(m) == 0 {
+		return n, nil
+	}
+	type span struct {
+		start, end netip.Addr
+	}
+	parse := func(mds []Metadata) ([]span, error) {
+		spans := make([]span, len(mds))
+		for i, md := range mds {
+			start, err := netip.ParseAddr(md.StartIP)
+			if err != nil || !start.Is6() {
+				return nil, &ParseError{Input: md.StartIP, Msg: "not an ipv6 address"}
+			}
+			end, err := netip.ParseAddr(md.EndIP)
+			if err != nil || !end.Is6() {
+				return nil, &ParseError{Input: md.EndIP, Msg: "not an ipv6 address"}
+			}
+			spans[i] = span{start, end}
+		}
+		return spans, nil
+	}
+	ns, err := parse(n)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := parse(m)
+	if err != nil {
+		return nil, err
+	}
 
-	return res
+	res := make([]Metadata, 0, len(n)+len(m))
+	keep := func(md Metadata, start, end netip.Addr) {
+		md.StartIP, md.EndIP = start.String(), end.String()
+		res = append(res, md)
+	}
+	k := 0
+	for i, md := range n {
+		for k < len(ms) && ms[k].end.Less(ns[i].start) {
+			k++
+		}
+		next, done := ns[i].start, false
+		for j := k; j < len(ms) && !ns[i].end.Less(ms[j].start); j++ {
+			if next.Less(ms[j].start) {
+				keep(md, next, ms[j].start.Prev())
+			}
+			if !ms[j].end.Less(ns[i].end) {
+				done = true
+				break
+			}
+			next = ms[j].end.Next()
+		}
+		if !done {
+			keep(md, next, ns[i].end)
+		}
+	}
+	res = append(res, m...)
+
+	starts := make(map[string]netip.Addr, len(res))
+	for _, md := range res {
+		starts[md.StartIP], _ = netip.ParseAddr(md.StartIP)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return starts[res[i].StartIP].Less(starts[res[j].StartIP])
+	})
+	return res, nil
 }
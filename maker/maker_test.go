@@ -1,139 +1,898 @@
 package maker
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/netip"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/hokitlee/go-ip2region/internal/mmdb"
+	ip2region "github.com/hokitlee/go-ip2region/query"
 )
 
+// openData opens a test file, the ip datasets are small fixtures in
+// testdata and the code tables those of the data directory.
+func openData(t *testing.T, path string) *os.File {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return f
+}
+
 func TestMaker_make(t *testing.T) {
-	rm := make(map[string]int)
-	pm := make(map[string]int)
-	im := make(map[string]int)
-	rmf, err := os.Open("../data/area_code.csv")
+	mf := openData(t, "testdata/ip.merge.txt")
+	defer mf.Close()
+	mds, err := ReadMerge(mf)
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
+
+	rmf := openData(t, "../data/area_code.csv")
 	defer rmf.Close()
-	rd := bufio.NewReader(rmf)
+	rm, pm, err := ReadAreaCodes(rmf)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	log.Println("read Province code file finish")
 
-	var province2Code []string
-	for {
-		bs, _, err := rd.ReadLine()
-		if err == io.EOF {
-			log.Println("read Province code file finish")
-			break
+	im := make(map[string]int)
+	isp := []string{"其他", "移动", "铁通", "联通", "电信", "内网IP"}
+	ispId := []int{0, 1, 1, 2, 3, 4}
+	for i := range isp {
+		im[isp[i]] = ispId[i]
+	}
+
+	dir, err := ioutil.TempDir("", "maker")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db.db")
+	maker := NewMaker(path, mds, rm, pm, im)
+	err = maker.Make()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ip2region.Verify(path); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	region, err := ip2region.New(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer region.Close()
+	for ip, want := range map[string]string{
+		"1.0.2.1":  "中国|福建|福州市|电信|4|46|3",
+		"1.0.9.1":  "中国|广东|广州市|电信|4|43|3",
+		"10.1.1.1": "0|0|内网IP|内网IP|0|0|4",
+		"8.8.8.8":  "日本|广岛县|0|0|0|0|0",
+	} {
+		info, err := region.BtreeSearch(ip)
+		if err != nil {
+			t.Fatalf("%s: %s", ip, err)
+		}
+		if got := info.String(); got != want {
+			t.Errorf("%s: got %s, want %s", ip, got, want)
 		}
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	mf := openData(t, "testdata/ip.txt")
+	defer mf.Close()
+	mds, err := ReadMerge(mf)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	mergeMetaData := make([]Metadata, 0)
+
+	mergeMetaData = append(mergeMetaData, Metadata{
+		StartIP:  "0.0.0.0",
+		EndIP:    "1.0.0.255",
+		Country:  "test",
+		Province: "test",
+		City:     "test",
+		Isp:      "test",
+	})
+
+	ms := MergeMetadata(mds, mergeMetaData)
+
+	var got []string
+	for _, n := range ms {
+		got = append(got, n.String())
+	}
+	want := []string{
+		"0.0.0.0|1.0.0.255|test|test|test|test",
+		"1.0.1.0|1.0.3.255|中国|福建|福州市|电信",
+		"1.0.4.0|255.255.255.255|美国|0|0|0",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMaker_vectorIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maker")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	provinces := []string{"北京", "广东", "浙江", "0"}
+	var mds []Metadata
+	for i := int64(0); i < 3000; i++ {
+		mds = append(mds, Metadata{
+			StartIP:  IpLong2String(i << 20),
+			EndIP:    IpLong2String(i<<20 + 1<<19),
+			Country:  "中国",
+			Province: provinces[i%4],
+			City:     "0",
+			Isp:      "电信",
+		})
+	}
+
+	plainPath, vectorPath := filepath.Join(dir, "plain.db"), filepath.Join(dir, "vector.db")
+	pm := map[string]int{"北京": 11, "广东": 44, "浙江": 33}
+	if err := NewMaker(plainPath, mds, nil, pm, nil).Make(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := NewMaker(vectorPath, mds, nil, pm, nil, WithVectorIndex()).Make(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, path := range []string{plainPath, vectorPath} {
+		if err := ip2region.Verify(path); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	plain, err := ip2region.New(plainPath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer plain.Close()
+	vector, err := ip2region.New(vectorPath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer vector.Close()
+
+	for i, md := range mds {
+		want := md.Province
+		for _, ip := range []string{md.StartIP, md.EndIP} {
+			for _, search := range []func(string) (ip2region.IpInfo, error){
+				plain.BtreeSearch, vector.MemorySearch, vector.BinarySearch,
+			} {
+				info, err := search(ip)
+				if err != nil {
+					t.Fatalf("%s: %s", ip, err)
+				}
+				if info.Province != want || info.ProvinceId != int64(pm[want]) {
+					t.Fatalf("%s: got %v, want province %s", ip, info, want)
+				}
+			}
+		}
+		gap := IpLong2String(int64(i)<<20 + 1<<19 + 1)
+		if _, err := vector.MemorySearch(gap); err == nil {
+			t.Fatalf("%s: expected not found", gap)
+		}
+	}
+}
+
+func TestMaker_ipv6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maker")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mds := []Metadata{
+		{StartIP: "0.0.0.0", EndIP: "9.255.255.255", Country: "中国", Province: "北京", City: "0", Isp: "联通"},
+		{StartIP: "2001:db8::", EndIP: "2001:db8::ffff", Country: "中国", Province: "北京", City: "0", Isp: "联通"},
+		{StartIP: "10.0.0.0", EndIP: "10.255.255.255", Country: "0", Province: "0", City: "0", Isp: "内网IP"},
+		{StartIP: "2400::", EndIP: "24ff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", Country: "中国", Province: "广东", City: "0", Isp: "电信"},
+	}
+	for _, only6 := range []bool{false, true} {
+		path := filepath.Join(dir, "ip.db")
+		input := mds
+		if only6 {
+			input = []Metadata{mds[1], mds[3]}
+		}
+		if err := NewMaker(path, input, nil, nil, nil, WithVectorIndex()).Make(); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if err := ip2region.Verify(path); err != nil {
+			t.Fatalf("%s", err)
+		}
+		region, err := ip2region.New(path)
 		if err != nil {
 			t.Fatalf("%s", err)
 		}
-		province2Code = append(province2Code, string(bs))
+
+		for _, c := range []struct {
+			ip       string
+			province string
+		}{
+			{"2001:db8::1", "北京"},
+			{"2408:8000::1", "广东"},
+			{"2001:db9::", ""},
+			{"1.2.3.4", "北京"},
+			{"10.1.1.1", "0"},
+		} {
+			if only6 && strings.IndexByte(c.ip, ':') < 0 {
+				c.province = ""
+			}
+			for _, search := range []func(string) (ip2region.IpInfo, error){
+				region.MemorySearch, region.BinarySearch, region.BtreeSearch,
+			} {
+				info, err := search(c.ip)
+				if c.province == "" {
+					if err == nil {
+						t.Fatalf("%s: got %v, want not found", c.ip, info)
+					}
+					continue
+				}
+				if err != nil || info.Province != c.province {
+					t.Fatalf("%s: got %v, %v, want province %s", c.ip, info, err, c.province)
+				}
+			}
+		}
+		region.Close()
+		os.Remove(path)
+	}
+}
+
+func TestMaker_extra(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maker")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mds := []Metadata{
+		{StartIP: "0.0.0.0", EndIP: "255.255.255.255", Country: "中国", Province: "北京", City: "0", Isp: "联通"},
+		{StartIP: "2001:db8::", EndIP: "2001:db8::ffff", Country: "中国", Province: "北京", City: "0", Isp: "联通"},
+		{StartIP: "2400::", EndIP: "2400::ffff", Country: "中国", Province: "广东", City: "0", Isp: "电信"},
+	}
+	extra := []Metadata{
+		{StartIP: "1.0.0.0", EndIP: "1.0.0.255", Country: "中国", Province: "上海", City: "0", Isp: "电信"},
+		{StartIP: "2001:db8::100", EndIP: "2001:db8::1ff", Country: "中国", Province: "上海", City: "0", Isp: "电信"},
+		{StartIP: "2400:1::", EndIP: "2400:1::ff", Country: "中国", Province: "浙江", City: "0", Isp: "移动"},
+	}
+	merged, err := MergeMetadataErr(mds, extra)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var got []string
+	for _, md := range merged {
+		got = append(got, md.StartIP+"-"+md.EndIP+" "+md.Province)
+	}
+	want := []string{
+		"0.0.0.0-0.255.255.255 北京",
+		"1.0.0.0-1.0.0.255 上海",
+		"1.0.1.0-255.255.255.255 北京",
+		"2001:db8::-2001:db8::ff 北京",
+		"2001:db8::100-2001:db8::1ff 上海",
+		"2001:db8::200-2001:db8::ffff 北京",
+		"2400::-2400::ffff 广东",
+		"2400:1::-2400:1::ff 浙江",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	path := filepath.Join(dir, "ip.db")
+	if err := NewMaker(path, mds, nil, nil, nil).Make(extra...); err != nil {
+		t.Fatalf("%s", err)
+	}
+	region, err := ip2region.New(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer region.Close()
+	for ip, province := range map[string]string{
+		"0.0.0.1": "北京", "1.0.0.1": "上海", "8.8.8.8": "北京",
+		"2001:db8::1": "北京", "2001:db8::101": "上海", "2001:db8::201": "北京", "2400:1::1": "浙江",
+	} {
+		info, err := region.BtreeSearch(ip)
+		if err != nil || info.Province != province {
+			t.Errorf("%s: got %v, %v, want province %s", ip, info, err, province)
+		}
+	}
+
+	invalid := Metadata{StartIP: "1.0.0.x", EndIP: "1.0.0.255"}
+	if err := NewMaker(path, mds, nil, nil, nil).Make(invalid); err == nil {
+		t.Fatal("expected an error for an invalid extra ip")
 	}
+	if _, err := MergeMetadataErr(mds, []Metadata{invalid}); err == nil {
+		t.Fatal("expected an error for an invalid extra ip")
+	}
+	// MergeMetadata leaves invalid ranges out
+	if merged := MergeMetadata(mds, append([]Metadata{invalid}, extra...)); len(merged) != len(want) {
+		t.Fatalf("got %v", merged)
+	}
+}
+
+func TestMaker_metadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maker")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
 
-	for _, n := range province2Code {
-		ss := strings.Split(n, ",")
-		pId, err := strconv.Atoi(ss[1])
+	mds := []Metadata{
+		{StartIP: "1.0.0.0", EndIP: "1.0.0.255", Country: "中国", Province: "北京", City: "0", Isp: "联通"},
+		{StartIP: "1.0.1.0", EndIP: "1.0.3.255", Country: "中国", Province: "北京", City: "0", Isp: "联通"},
+		{StartIP: "2.0.0.0", EndIP: "2.0.0.255", Country: "美国", Province: "0", City: "0", Isp: "0"},
+		{StartIP: "2001:db8::", EndIP: "2001:db8::ffff", Country: "中国", Province: "广东", City: "0", Isp: "电信"},
+	}
+	buildTime := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	var dbs [][]byte
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("ip%d.db", i))
+		err := NewMaker(path, mds, nil, nil, nil,
+			WithSource("ip.merge.txt", "20240501"),
+			WithCodeTable("area_code.csv", "2023"),
+			WithBuildTime(buildTime)).Make()
 		if err != nil {
 			t.Fatalf("%s", err)
 		}
-		rId, err := strconv.Atoi(ss[0])
+		if err := ip2region.Verify(path); err != nil {
+			t.Fatalf("%s", err)
+		}
+		b, err := ioutil.ReadFile(path)
 		if err != nil {
 			t.Fatalf("%s", err)
 		}
-		rm[ss[2]] = rId
-		pm[ss[2]] = pId
+		dbs = append(dbs, b)
+
+		region, err := ip2region.New(path)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		md := region.Metadata()
+		region.Close()
+		if md == nil {
+			t.Fatal("expected metadata")
+		}
+		if md.FormatVersion != ip2region.FormatVersion || !md.BuildTime.Equal(buildTime) ||
+			md.IPv4Ranges != 3 || md.IPv6Ranges != 1 || md.DataBlocks != 3 ||
+			len(md.Sources) != 1 || md.Sources[0].Version != "20240501" ||
+			len(md.CodeTables) != 1 || md.CodeTables[0].Name != "area_code.csv" {
+			t.Fatalf("metadata %+v", md)
+		}
 	}
-	isp := []string{"其他", "移动", "铁通", "联通", "电信", "内网IP"}
-	ispId := []int{0, 1, 1, 2, 3, 4}
-	for i := range isp {
-		im[isp[i]] = ispId[i]
+	if string(dbs[0]) != string(dbs[1]) {
+		t.Fatal("expected identical dbs from identical inputs")
 	}
+}
 
-	mf, err := os.Open("../data/ip.merge.txt")
+func TestMaker_mmdb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maker")
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
+	defer os.RemoveAll(dir)
 
-	mfrd := bufio.NewReader(mf)
-	var mds []Metadata
+	mds := []Metadata{
+		{StartIP: "0.0.0.0", EndIP: "0.255.255.255", Country: "0", Province: "0", City: "0", Isp: "0"},
+		{StartIP: "1.0.0.0", EndIP: "1.0.0.255", Country: "中国", Province: "北京", City: "北京市", Isp: "联通"},
+		{StartIP: "1.0.1.0", EndIP: "1.2.3.4", Country: "中国", Province: "广东", City: "0", Isp: "电信"},
+		{StartIP: "1.2.3.5", EndIP: "255.255.255.255", Country: "美国", Province: "0", City: "0", Isp: "0"},
+		{StartIP: "2001:db8::", EndIP: "2001:db8::ffff", Country: "中国", Province: "北京", City: "北京市", Isp: "联通"},
+	}
+	rm := map[string]int{"北京": 110000, "广东": 440000}
+	pm := map[string]int{"北京": 11, "广东": 44}
+	im := map[string]int{"联通": 2, "电信": 3}
+	buildTime := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
 
-	for {
-		bs, _, err := mfrd.ReadLine()
-		if err == io.EOF {
-			log.Println("read Province code file finish")
-			break
+	for _, input := range [][]Metadata{mds, mds[:4]} {
+		path := filepath.Join(dir, "ip.mmdb")
+		err := NewMaker(filepath.Join(dir, "ip.db"), input, rm, pm, im, WithMMDB(path), WithBuildTime(buildTime)).Make()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		ipVersion := 4
+		if len(input) == len(mds) {
+			ipVersion = 6
 		}
+
+		// the file is checked against the MaxMind DB spec by the decoder
+		// below, independent of the query package reading it
+		b, err := ioutil.ReadFile(path)
 		if err != nil {
 			t.Fatalf("%s", err)
 		}
+		meta, tree, data := splitMMDB(t, b)
+		if meta["database_type"] != MMDBDatabaseType || meta["build_epoch"] != uint64(buildTime.Unix()) {
+			t.Fatalf("metadata %v", meta)
+		}
+		if meta["ip_version"] != uint64(ipVersion) {
+			t.Fatalf("ip version %v, want %d", meta["ip_version"], ipVersion)
+		}
 
-		ss := strings.Split(string(bs), "|")
-		mds = append(mds, Metadata{
-			StartIP:  ss[0],
-			EndIP:    ss[1],
-			Country:  ss[2],
-			Province: ss[4],
-			City:     ss[5],
-			Isp:      ss[6],
-		})
+		m, err := ip2region.OpenMMDB(path)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if meta := m.Metadata(); meta.DatabaseType != MMDBDatabaseType || meta.BuildEpoch != uint64(buildTime.Unix()) ||
+			meta.IPVersion != ipVersion {
+			t.Fatalf("metadata %+v", meta)
+		}
+
+		for _, c := range []struct {
+			ip     string
+			record string
+			info   string
+		}{
+			{"0.1.2.3", "", ""},
+			{"1.0.0.1", "map[city:北京市 country:中国 isp:联通 isp_id:2 province:北京 province_id:11 region_id:110000]", "中国|北京|北京市|联通|110000|11|2"},
+			{"1.2.3.4", "map[country:中国 isp:电信 isp_id:3 province:广东 province_id:44 region_id:440000]", "中国|广东|0|电信|440000|44|3"},
+			{"1.2.3.5", "map[country:美国]", "美国|0|0|0|0|0|0"},
+			{"255.255.255.255", "map[country:美国]", "美国|0|0|0|0|0|0"},
+			{"2001:db8::1", "map[city:北京市 country:中国 isp:联通 isp_id:2 province:北京 province_id:11 region_id:110000]", "中国|北京|北京市|联通|110000|11|2"},
+			{"::ffff:1.0.0.1", "map[city:北京市 country:中国 isp:联通 isp_id:2 province:北京 province_id:11 region_id:110000]", "中国|北京|北京市|联通|110000|11|2"},
+			{"2002:102:304::", "map[country:中国 isp:电信 isp_id:3 province:广东 province_id:44 region_id:440000]", "中国|广东|0|电信|440000|44|3"},
+			{"2001:db9::", "", ""},
+		} {
+			ip := netip.MustParseAddr(c.ip)
+			if ipVersion == 4 && !ip.Is4() {
+				continue
+			}
+			got := ""
+			if v, ok := lookupMMDB(t, meta, tree, data, ip); ok {
+				got = fmt.Sprint(v)
+			}
+			if got != c.record {
+				t.Fatalf("%s: got record %q, want %q", c.ip, got, c.record)
+			}
+
+			got = ""
+			if info, err := m.Search(c.ip); err == nil {
+				got = info.String()
+			} else if !errors.Is(err, ip2region.ErrNotFound) {
+				t.Fatalf("%s: %s", c.ip, err)
+			}
+			if got != c.info {
+				t.Fatalf("%s: got %q, want %q", c.ip, got, c.info)
+			}
+		}
+
+		// reading the mmdb back gives the input, gaps filled with unknown
+		// ranges
+		back, err := ReadMMDB(path)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(back) != len(input) {
+			t.Fatalf("read back %v", back)
+		}
+		for i := range back {
+			if back[i] != input[i] {
+				t.Fatalf("range %d: got %v, want %v", i, back[i], input[i])
+			}
+		}
+	}
+}
+
+// splitMMDB returns the decoded metadata, search tree and data section of
+// an mmdb file.
+func splitMMDB(t *testing.T, b []byte) (meta map[string]interface{}, tree, data []byte) {
+	i := bytes.LastIndex(b, []byte(mmdb.MetadataStart))
+	if i < 0 {
+		t.Fatal("no mmdb metadata")
+	}
+	v, _ := decodeMMDB(t, b[i+len(mmdb.MetadataStart):], 0)
+	meta = v.(map[string]interface{})
+	size := int(meta["node_count"].(uint64) * meta["record_size"].(uint64) / 4)
+	if !bytes.Equal(b[size:size+mmdb.DataSeparator], make([]byte, mmdb.DataSeparator)) {
+		t.Fatal("no data section separator after the search tree")
+	}
+	return meta, b[:size], b[size+mmdb.DataSeparator : i]
+}
+
+// lookupMMDB walks the search tree for ip and decodes its data record.
+func lookupMMDB(t *testing.T, meta map[string]interface{}, tree, data []byte, ip netip.Addr) (interface{}, bool) {
+	nodeCount := int(meta["node_count"].(uint64))
+	recordSize := int(meta["record_size"].(uint64))
+	key := ip.AsSlice()
+	if meta["ip_version"] == uint64(6) && ip.Is4() {
+		key = append(make([]byte, 12), key...)
+	}
+	node := 0
+	for i := 0; i < len(key)*8 && node < nodeCount; i++ {
+		bit := int(key[i/8]>>(7-i%8)) & 1
+		n := tree[node*recordSize/4:]
+		switch {
+		case recordSize == 24:
+			n = n[bit*3:]
+			node = int(n[0])<<16 | int(n[1])<<8 | int(n[2])
+		case recordSize == 28 && bit == 0:
+			node = int(n[3]>>4)<<24 | int(n[0])<<16 | int(n[1])<<8 | int(n[2])
+		case recordSize == 28:
+			node = int(n[3]&0x0F)<<24 | int(n[4])<<16 | int(n[5])<<8 | int(n[6])
+		default:
+			node = int(binary.BigEndian.Uint32(n[bit*4:]))
+		}
+	}
+	if node == nodeCount {
+		return nil, false
+	}
+	if node < nodeCount {
+		t.Fatalf("%s: search tree deeper than the address", ip)
+	}
+	v, _ := decodeMMDB(t, data, node-nodeCount-mmdb.DataSeparator)
+	return v, true
+}
+
+// decodeMMDB decodes the strings, unsigned integers, maps and arrays this
+// package writes.
+func decodeMMDB(t *testing.T, b []byte, off int) (interface{}, int) {
+	ctrl := b[off]
+	off++
+	typ := int(ctrl >> 5)
+	if typ == 0 {
+		typ = int(b[off]) + 7
+		off++
+	}
+	size := int(ctrl & 0x1F)
+	switch size {
+	case 29:
+		size = 29 + int(b[off])
+		off++
+	case 30:
+		size = 285 + (int(b[off])<<8 | int(b[off+1]))
+		off += 2
+	case 31:
+		size = 65821 + (int(b[off])<<16 | int(b[off+1])<<8 | int(b[off+2]))
+		off += 3
+	}
+	switch typ {
+	case mmdb.String:
+		return string(b[off : off+size]), off + size
+	case mmdb.Uint16, mmdb.Uint32, mmdb.Uint64:
+		var v uint64
+		for _, c := range b[off : off+size] {
+			v = v<<8 | uint64(c)
+		}
+		return v, off + size
+	case mmdb.Map:
+		m := make(map[string]interface{})
+		for i := 0; i < size; i++ {
+			var k, v interface{}
+			k, off = decodeMMDB(t, b, off)
+			v, off = decodeMMDB(t, b, off)
+			m[k.(string)] = v
+		}
+		return m, off
+	case mmdb.Array:
+		a := make([]interface{}, size)
+		for i := range a {
+			a[i], off = decodeMMDB(t, b, off)
+		}
+		return a, off
 	}
+	t.Fatalf("unexpected mmdb type %d", typ)
+	return nil, 0
+}
 
-	maker := NewMaker("./db.db", mds, rm, pm, im)
-	err = maker.make()
+func TestMaker_upstream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maker")
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ip.db")
+	mds := []Metadata{
+		{StartIP: "1.0.0.0", EndIP: "1.0.0.255", Country: "中国", Province: "北京", City: "北京市", Isp: "联通"},
+		{StartIP: "2.0.0.0", EndIP: "2.0.0.255", Country: "美国", Province: "0", City: "0", Isp: "0"},
+	}
+	rm, pm, im := map[string]int{"北京": 110000}, map[string]int{"北京": 11}, map[string]int{"联通": 2}
+	if err := NewMaker(path, mds, rm, pm, im, WithUpstreamLayout()).Make(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ip2region.Verify(path); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dataPtr := ip2region.GetLong(b, ip2region.GetLong(b, 0)+8)
+	ptr, length := dataPtr&0x00FFFFFF, dataPtr>>24&0xFF
+	if data := string(b[ptr : ptr+length]); data != "\x00\x00\x00\x00中国|0|北京|北京市|联通" {
+		t.Fatalf("data block %q", data)
+	}
+
+	region, err := ip2region.New(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if md := region.Metadata(); md == nil || md.DataLayout != ip2region.DataLayoutUpstream {
+		t.Fatalf("metadata %+v", md)
+	}
+	info, err := region.BtreeSearch("1.0.0.1")
+	region.Close()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if want := (ip2region.IpInfo{Country: "中国", Province: "北京", City: "北京市", ISP: "联通"}); info != want {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+
+	codes := &ip2region.CodeTables{
+		RegionIds:   map[string]int64{"北京": 110000},
+		ProvinceIds: map[string]int64{"北京": 11},
+		ISPIds:      map[string]int64{"联通": 2},
+	}
+	s, err := ip2region.NewSearcher(path, ip2region.WithCodeTables(codes))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer s.Close()
+	info, err = s.Search("1.0.0.1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if info.RegionId != 110000 || info.ProvinceId != 11 || info.ISPId != 2 {
+		t.Fatalf("got %+v", info)
+	}
 }
 
-func TestMergeMetadata(t *testing.T) {
-	mf, err := os.Open("../data/ip.txt")
+func TestMaker_xdb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maker")
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
-	mfrd := bufio.NewReader(mf)
-	var mds []Metadata
+	defer os.RemoveAll(dir)
 
+	path := filepath.Join(dir, "ip2region.xdb")
+	mds := []Metadata{
+		{StartIP: "0.0.0.0", EndIP: "0.255.255.255", Country: "0", Province: "0", City: "0", Isp: "内网IP"},
+		{StartIP: "1.0.0.0", EndIP: "1.2.3.255", Country: "中国", Province: "广东", City: "深圳市", Isp: "电信"},
+		{StartIP: "1.2.4.0", EndIP: "255.255.255.255", Country: "美国", Province: "0", City: "0", Isp: "0"},
+		{StartIP: "2001:db8::", EndIP: "2001:db8::ffff", Country: "中国", Province: "北京", City: "0", Isp: "联通"},
+	}
+	buildTime := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	if err := NewMaker(path, mds, nil, nil, nil, WithXdb(), WithBuildTime(buildTime)).Make(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ip2region.Verify(path); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	x, err := ip2region.OpenXdb(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer x.Close()
+	if h := x.Header(); h.Version != XdbVersion || !h.CreatedAt.Equal(buildTime) {
+		t.Fatalf("header %+v", h)
+	}
+	info, err := x.Search("1.2.3.4")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if want := (ip2region.IpInfo{Country: "中国", Province: "广东", City: "深圳市", ISP: "电信"}); info != want {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+	ranges, err := x.Ranges()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(ranges) != 3 {
+		t.Fatalf("ranges %+v", ranges)
+	}
+	for i, r := range ranges {
+		if r.Start.String() != mds[i].StartIP || r.End.String() != mds[i].EndIP {
+			t.Fatalf("range %d: %+v, want %v", i, r, mds[i])
+		}
+	}
+
+	if err := NewMaker(path, mds[3:], nil, nil, nil, WithXdb()).Make(); err == nil {
+		t.Fatal("expected an error for an xdb without ipv4 ranges")
+	}
+}
+
+func TestIpString2Int64(t *testing.T) {
+	if n, err := IpString2Int64("1.2.3.4"); err != nil || n != 0x01020304 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	for _, in := range []string{"1.2.3.x", "300.1.1.1", "-1.0.0.0", "1.2.3", "1.2.3.4.5", "01.2.3.4", ""} {
+		if _, err := IpString2Int64(in); err == nil {
+			t.Errorf("IpString2Int64(%q) should fail", in)
+		}
+	}
+}
+
+func TestReadCodes(t *testing.T) {
+	f, err := os.Open("../data/area_code.csv")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer f.Close()
+	rm, pm, err := ReadAreaCodes(f)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if rm["北京"] != 1 || pm["北京"] != 11 || pm["河北"] != 13 {
+		t.Fatalf("got region %d, province %d", rm["北京"], pm["北京"])
+	}
+
+	f, err = os.Open("../data/isp_code.csv")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer f.Close()
+	im, err := ReadISPCodes(f)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if im["联通"] != 2 || im["铁通"] != 1 {
+		t.Fatalf("got %v", im)
+	}
+
+	if _, _, err := ReadAreaCodes(strings.NewReader("1,11,北京\n1,x,天津\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected an error for line 2, got %v", err)
+	}
+}
+
+func TestMergeReader(t *testing.T) {
+	in := "\ufeff# ip.merge.txt\n" +
+		"0.0.0.0|0.255.255.255|0|0|0|0|0\r\n" +
+		"\n" +
+		"1.0.0.0|1.0.0.255|中国|华南|广东|深圳市|电信\n" +
+		"2001:db8::|2001:db8::ffff|中国|0|北京|北京市|联通\n"
+	mr := NewMergeReader(strings.NewReader(in))
+	var lines []int
+	var mds []Metadata
 	for {
-		bs, _, err := mfrd.ReadLine()
+		md, err := mr.Read()
 		if err == io.EOF {
-			log.Println("read Province code file finish")
 			break
 		}
 		if err != nil {
 			t.Fatalf("%s", err)
 		}
+		lines = append(lines, mr.Line())
+		mds = append(mds, md)
+	}
+	if fmt.Sprint(lines) != "[2 4 5]" || len(mds) != 3 {
+		t.Fatalf("lines %v, ranges %v", lines, mds)
+	}
+	if mds[1] != (Metadata{StartIP: "1.0.0.0", EndIP: "1.0.0.255", Country: "中国", Province: "广东", City: "深圳市", Isp: "电信"}) {
+		t.Fatalf("got %v", mds[1])
+	}
 
-		ss := strings.Split(string(bs), "|")
-		mds = append(mds, Metadata{
-			StartIP:  ss[0],
-			EndIP:    ss[1],
-			Country:  ss[2],
-			Province: ss[4],
-			City:     ss[5],
-			Isp:      ss[6],
-		})
+	// the area is dropped, everything else round trips
+	var buf strings.Builder
+	if err := WriteMerge(&buf, mds); err != nil {
+		t.Fatalf("%s", err)
+	}
+	want := "0.0.0.0|0.255.255.255|0|0|0|0|0\n" +
+		"1.0.0.0|1.0.0.255|中国|0|广东|深圳市|电信\n" +
+		"2001:db8::|2001:db8::ffff|中国|0|北京|北京市|联通\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+	again, err := ReadMerge(strings.NewReader(buf.String()))
+	if err != nil || fmt.Sprint(again) != fmt.Sprint(mds) {
+		t.Fatalf("round trip: %v, %v", again, err)
 	}
 
-	mergeMetaData := make([]Metadata, 0)
+	for _, c := range []struct {
+		in   string
+		line int
+	}{
+		{"1.0.0.0|1.0.0.255|中国\n", 1},
+		{"# c\n1.0.0.0|1.0.0.255|中国|0|0|0|0\n1.0.0.0|1.0.0.x|中国|0|0|0|0\n", 3},
+		{"1.0.0.9|1.0.0.0|中国|0|0|0|0\n", 1},
+		{"1.0.0.0|2001:db8::|中国|0|0|0|0\n", 1},
+	} {
+		_, err := ReadMerge(strings.NewReader(c.in))
+		var merr *MergeError
+		if !errors.As(err, &merr) || merr.Line != c.line {
+			t.Errorf("%q: got %v, want an error on line %d", c.in, err, c.line)
+		}
+	}
 
-	mergeMetaData = append(mergeMetaData, Metadata{
-		StartIP:  "0.0.0.0",
-		EndIP:    "1.0.0.255",
-		Country:  "test",
-		Province: "test",
-		City:     "test",
-		Isp:      "test",
-	})
+	if err := WriteMerge(io.Discard, []Metadata{{StartIP: "1.0.0.0", EndIP: "1.0.0.1", Country: "a|b"}}); err == nil {
+		t.Fatal("expected an error for a field containing the separator")
+	}
+}
 
-	ms := MergeMetadata(mds, mergeMetaData)
+func TestGeoLite2(t *testing.T) {
+	locations := "geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name,city_name,metro_code,time_zone,is_in_european_union\n" +
+		"1809858,zh-CN,AS,亚洲,CN,中国,GD,广东省,,,广州,,Asia/Shanghai,0\n" +
+		"1814991,zh-CN,AS,亚洲,CN,中国,,,,,,,Asia/Shanghai,0\n" +
+		"6252001,zh-CN,NA,北美洲,US,美国,,,,,,,America/Chicago,0\n"
+	blocks4 := "network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius\n" +
+		"1.0.1.0/24,1809858,1814991,,0,0,,23.1167,113.25,50\n" +
+		"1.0.2.0/23,1809858,1814991,,0,0,,23.1167,113.25,50\n" +
+		"3.0.0.0/8,,6252001,,0,0,,,,1000\n"
+	blocks6 := "network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius\n" +
+		"::ffff:1.0.1.0/120,1809858,1814991,,0,0,,,,50\n" +
+		"2400:3200::/32,1814991,1814991,,0,0,,,,100\n"
+	asn4 := "network,autonomous_system_number,autonomous_system_organization\n" +
+		"1.0.2.0/24,4134,CHINANET-BACKBONE\n" +
+		"3.0.0.0/9,16509,AMAZON-02\n"
 
-	for _, n := range ms {
-		fmt.Printf("%-10v \n", n)
+	g := GeoLite2{ProvinceCodes: map[string]int{"广东": 44}}
+	for _, c := range []struct {
+		read func(io.Reader) error
+		in   string
+	}{
+		{g.ReadLocations, locations}, {g.ReadCityBlocks, blocks4}, {g.ReadCityBlocks, blocks6}, {g.ReadASNBlocks, asn4},
+	} {
+		if err := c.read(strings.NewReader(c.in)); err != nil {
+			t.Fatalf("%s", err)
+		}
 	}
 
+	var got []string
+	for _, md := range g.Metadata() {
+		got = append(got, md.String())
+	}
+	want := []string{
+		"0.0.0.0|1.0.0.255|0|0|0|0",
+		"1.0.1.0|1.0.1.255|中国|广东|广州|0",
+		"1.0.2.0|1.0.2.255|中国|广东|广州|电信",
+		"1.0.3.0|1.0.3.255|中国|广东|广州|0",
+		"1.0.4.0|2.255.255.255|0|0|0|0",
+		"3.0.0.0|3.127.255.255|美国|0|0|AMAZON-02",
+		"3.128.0.0|3.255.255.255|美国|0|0|0",
+		"4.0.0.0|255.255.255.255|0|0|0|0",
+		"2400:3200::|2400:3200:ffff:ffff:ffff:ffff:ffff:ffff|中国|0|0|0",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// the Chinese dataset takes precedence where it has data, the IPv6
+	// ranges are merged apart from the IPv4 ones
+	merged, err := MergeMetadataErr(g.Metadata(), []Metadata{
+		{StartIP: "1.0.1.0", EndIP: "1.0.3.255", Country: "中国", Province: "广东", City: "深圳市", Isp: "电信"},
+		{StartIP: "2400:3200::", EndIP: "2400:3200::ffff", Country: "中国", Province: "浙江", City: "杭州市", Isp: "阿里云"},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	got = got[:0]
+	for _, md := range merged {
+		got = append(got, md.String())
+	}
+	want = []string{
+		"0.0.0.0|1.0.0.255|0|0|0|0",
+		"1.0.1.0|1.0.3.255|中国|广东|深圳市|电信",
+		"1.0.4.0|2.255.255.255|0|0|0|0",
+		"3.0.0.0|3.127.255.255|美国|0|0|AMAZON-02",
+		"3.128.0.0|3.255.255.255|美国|0|0|0",
+		"4.0.0.0|255.255.255.255|0|0|0|0",
+		"2400:3200::|2400:3200::ffff|中国|浙江|杭州市|阿里云",
+		"2400:3200::1:0|2400:3200:ffff:ffff:ffff:ffff:ffff:ffff|中国|0|0|0",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("merged\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := g.ReadCityBlocks(strings.NewReader("network,geoname_id,registered_country_geoname_id\n1.0.0.0/33,1,1\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected an error on line 2, got %v", err)
+	}
+	if err := g.ReadASNBlocks(strings.NewReader("network,asn\n")); err == nil {
+		t.Fatal("expected an error for a missing column")
+	}
 }
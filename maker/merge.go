@@ -0,0 +1,189 @@
+package maker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// The ip.merge.txt format has one range per line:
+//
+//	startIp|endIp|country|area|province|city|isp
+//
+// Unknown fields are "0". The area field is not stored in the db, it is
+// dropped when reading and written as "0". Blank lines and lines starting
+// with '#' are skipped.
+const mergeFields = 7
+
+// MergeError reports a malformed line of an ip.merge.txt file.
+type MergeError struct {
+	Line int
+	Err  error
+}
+
+func (e *MergeError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *MergeError) Unwrap() error {
+	return e.Err
+}
+
+// MergeReader reads ranges in the ip.merge.txt format one at a time.
+type MergeReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewMergeReader returns a MergeReader reading from r.
+func NewMergeReader(r io.Reader) *MergeReader {
+	return &MergeReader{scanner: bufio.NewScanner(r)}
+}
+
+// Read returns the next range, or io.EOF after the last one. Malformed
+// lines are reported as a *MergeError.
+func (mr *MergeReader) Read() (Metadata, error) {
+	for mr.scanner.Scan() {
+		mr.line++
+		text := strings.TrimRight(mr.scanner.Text(), "\r")
+		if mr.line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		ss := strings.Split(text, "|")
+		if len(ss) != mergeFields {
+			return Metadata{}, &MergeError{Line: mr.line, Err: fmt.Errorf("%d fields, want %d", len(ss), mergeFields)}
+		}
+		md := Metadata{
+			StartIP:  ss[0],
+			EndIP:    ss[1],
+			Country:  ss[2],
+			Province: ss[4],
+			City:     ss[5],
+			Isp:      ss[6],
+		}
+		if err := checkRange(md.StartIP, md.EndIP); err != nil {
+			return Metadata{}, &MergeError{Line: mr.line, Err: err}
+		}
+		return md, nil
+	}
+	if err := mr.scanner.Err(); err != nil {
+		return Metadata{}, err
+	}
+	return Metadata{}, io.EOF
+}
+
+// Line returns the line number of the range returned by the last Read.
+func (mr *MergeReader) Line() int {
+	return mr.line
+}
+
+// ReadMerge reads all ranges of an ip.merge.txt file.
+func ReadMerge(r io.Reader) ([]Metadata, error) {
+	var mds []Metadata
+	mr := NewMergeReader(r)
+	for {
+		md, err := mr.Read()
+		if err == io.EOF {
+			return mds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		mds = append(mds, md)
+	}
+}
+
+// WriteMerge writes mds in the ip.merge.txt format, empty fields are
+// written as "0".
+func WriteMerge(w io.Writer, mds []Metadata) error {
+	bw := bufio.NewWriter(w)
+	for i, md := range mds {
+		if err := checkRange(md.StartIP, md.EndIP); err != nil {
+			return fmt.Errorf("range %d: %s", i, err)
+		}
+		md.Format()
+		fields := []string{md.StartIP, md.EndIP, md.Country, "0", md.Province, md.City, md.Isp}
+		for _, f := range fields[2:] {
+			if strings.ContainsAny(f, "|\r\n") {
+				return fmt.Errorf("range %d: field %q contains a separator", i, f)
+			}
+		}
+		if _, err := bw.WriteString(strings.Join(fields, "|") + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// checkRange checks that start and end are ips of the same family and
+// start is not after end.
+func checkRange(start, end string) error {
+	if IsIPv6(start) != IsIPv6(end) {
+		return fmt.Errorf("start ip %s and end ip %s are of different families", start, end)
+	}
+	if IsIPv6(start) {
+		s, err := IpString2IPv6(start)
+		if err != nil {
+			return err
+		}
+		e, err := IpString2IPv6(end)
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(s, e) > 0 {
+			return fmt.Errorf("start ip %s is after end ip %s", start, end)
+		}
+		return nil
+	}
+	s, err := IpString2Int64(start)
+	if err != nil {
+		return err
+	}
+	e, err := IpString2Int64(end)
+	if err != nil {
+		return err
+	}
+	if s > e {
+		return fmt.Errorf("start ip %s is after end ip %s", start, end)
+	}
+	return nil
+}
+
+// fillIPv4Gaps fills the gaps between and around the IPv4 ranges of mds,
+// sorted by start ip, with unknown ranges, so that they cover the whole
+// address space like ip.merge.txt. The IPv6 ranges follow the IPv4 ones,
+// MergeMetadata merges both parts separately.
+func fillIPv4Gaps(mds []Metadata) []Metadata {
+	var v4, v6 []Metadata
+	next := netip.IPv4Unspecified()
+	for _, md := range mds {
+		start, err := netip.ParseAddr(md.StartIP)
+		if err != nil || !start.Is4() {
+			v6 = append(v6, md)
+			continue
+		}
+		if next.IsValid() && next.Less(start) {
+			v4 = append(v4, unknownMetadata(next, start.Prev()))
+		}
+		v4 = append(v4, md)
+		end, _ := netip.ParseAddr(md.EndIP)
+		next = end.Next()
+	}
+	if len(v4) > 0 && next.IsValid() {
+		v4 = append(v4, unknownMetadata(next, netip.AddrFrom4([4]byte{255, 255, 255, 255})))
+	}
+	return append(v4, v6...)
+}
+
+func unknownMetadata(start, end netip.Addr) Metadata {
+	md := Metadata{StartIP: start.String(), EndIP: end.String()}
+	md.Format()
+	return md
+}
@@ -0,0 +1,411 @@
+package maker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+
+	"github.com/hokitlee/go-ip2region/internal/cidr"
+	"github.com/hokitlee/go-ip2region/internal/mmdb"
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+/**
+ * MaxMind DB (mmdb) writer, see https://maxmind.github.io/MaxMind-DB/
+ * <p>
+ * +---------------+-----------+---------------+-----------+-----------+
+ * | search tree	| 16 zeros	| data section	| marker	| metadata	|
+ * +---------------+-----------+---------------+-----------+-----------+
+ * <p>
+ * every data record is a map of the region of a range, unknown fields
+ * are left out:
+ * {"country": "中国", "province": "广东", "city": "深圳市", "isp": "电信",
+ *  "region_id": 440000, "province_id": 44, "isp_id": 3}
+ * <p>
+ * ranges of an unknown region are not written, they are not found.
+ */
+
+// MMDBDatabaseType is the database_type of the MMDB metadata.
+const MMDBDatabaseType = ip2region.MMDBDatabaseType
+
+// WithMMDB makes the Maker also write the ranges as a MaxMind DB file at
+// path, for the tools that only read that format.
+func WithMMDB(path string) Option {
+	return func(mk *Maker) {
+		mk.mmdbPath = path
+	}
+}
+
+// ReadMMDB reads the ranges of the MaxMind DB file at path as a source
+// dataset, the options set how its records map to regions. The ids of the
+// records are dropped, the Maker looks them up in its code tables. Like
+// GeoLite2.Metadata the IPv4 ranges come first with their gaps filled with
+// unknown ranges.
+func ReadMMDB(path string, opts ...ip2region.MMDBOption) ([]Metadata, error) {
+	m, err := ip2region.OpenMMDB(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	var mds []Metadata
+	err = m.Iterate(func(r ip2region.Range) error {
+		mds = append(mds, Metadata{
+			StartIP:  r.Start.String(),
+			EndIP:    r.End.String(),
+			Country:  r.Country,
+			Province: r.Province,
+			City:     r.City,
+			Isp:      r.ISP,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fillIPv4Gaps(mds), nil
+}
+
+// makeMMDB writes the MMDB file of WithMMDB, if any.
+func (mk *Maker) makeMMDB() error {
+	if mk.mmdbPath == "" {
+		return nil
+	}
+	log.Println("+-Try to write the mmdb ... ")
+	if err := mk.writeMMDBFile(mk.mmdbPath); err != nil {
+		return err
+	}
+	log.Println("|--[Ok]")
+	return nil
+}
+
+// writeMMDBFile writes the ranges of the db as an MMDB file at path.
+func (mk *Maker) writeMMDBFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := mk.writeMMDB(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (mk *Maker) writeMMDB(w *bufio.Writer) error {
+	ipVersion := 4
+	for _, md := range mk.metadata {
+		if IsIPv6(md.StartIP) {
+			ipVersion = 6
+			break
+		}
+	}
+
+	var data mmdbEncoder
+	offsets := make(map[string]int)
+	tree := &mmdbTree{root: &mmdbNode{}}
+	for _, md := range mk.metadata {
+		dataBlock := mk.dataBlock(md)
+		if dataBlock.unknown() {
+			continue
+		}
+		start, err := netip.ParseAddr(md.StartIP)
+		if err != nil {
+			return err
+		}
+		end, err := netip.ParseAddr(md.EndIP)
+		if err != nil {
+			return err
+		}
+		if start, end = start.Unmap(), end.Unmap(); start.Is4() != end.Is4() || end.Less(start) {
+			return fmt.Errorf("invalid range %s-%s", md.StartIP, md.EndIP)
+		}
+
+		off, ok := offsets[md.RegionString()]
+		if !ok {
+			off = len(data.b)
+			offsets[md.RegionString()] = off
+			data.region(dataBlock)
+		}
+		for _, p := range cidr.Prefixes(start, end) {
+			key, bits := p.Addr().AsSlice(), p.Bits()
+			if ipVersion == 6 && p.Addr().Is4() {
+				// ipv4 lives in ::/96 of an ipv6 tree
+				key, bits = append(make([]byte, 12), key...), bits+96
+			}
+			tree.insert(key, bits, off+1)
+		}
+	}
+	if ipVersion == 6 {
+		// ipv4-mapped and 6to4 addresses find the ipv4 ranges
+		tree.alias(netip.MustParseAddr("::ffff:0:0").AsSlice(), 96)
+		tree.alias(netip.MustParseAddr("2002::").AsSlice(), 16)
+	}
+
+	nodes, index := tree.nodes()
+	nodeCount := len(nodes)
+	recordSize := 32
+	switch max := nodeCount + mmdb.DataSeparator + len(data.b); {
+	case max < 1<<24:
+		recordSize = 24
+	case max < 1<<28:
+		recordSize = 28
+	}
+	record := func(n *mmdbNode, bit int) uint32 {
+		if c := n.child[bit]; c != nil {
+			return uint32(index[c])
+		}
+		if d := n.data[bit]; d != 0 {
+			return uint32(nodeCount + mmdb.DataSeparator + d - 1)
+		}
+		return uint32(nodeCount)
+	}
+
+	b := make([]byte, recordSize/4)
+	for _, n := range nodes {
+		left, right := record(n, 0), record(n, 1)
+		switch recordSize {
+		case 24:
+			b[0], b[1], b[2] = byte(left>>16), byte(left>>8), byte(left)
+			b[3], b[4], b[5] = byte(right>>16), byte(right>>8), byte(right)
+		case 28:
+			b[0], b[1], b[2] = byte(left>>16), byte(left>>8), byte(left)
+			b[3] = byte(left>>24)<<4 | byte(right>>24)&0x0F
+			b[4], b[5], b[6] = byte(right>>16), byte(right>>8), byte(right)
+		default:
+			binary.BigEndian.PutUint32(b, left)
+			binary.BigEndian.PutUint32(b[4:], right)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	log.Printf("+- mmdb %d nodes, %d byte records, %d data records \n", nodeCount, recordSize, len(offsets))
+
+	if _, err := w.Write(make([]byte, mmdb.DataSeparator)); err != nil {
+		return err
+	}
+	if _, err := w.Write(data.b); err != nil {
+		return err
+	}
+
+	var meta mmdbEncoder
+	meta.control(mmdb.Map, 9)
+	meta.string("binary_format_major_version")
+	meta.uint(mmdb.Uint16, 2)
+	meta.string("binary_format_minor_version")
+	meta.uint(mmdb.Uint16, 0)
+	meta.string("build_epoch")
+	meta.uint(mmdb.Uint64, uint64(mk.buildTime.Unix()))
+	meta.string("database_type")
+	meta.string(MMDBDatabaseType)
+	meta.string("description")
+	meta.control(mmdb.Map, 1)
+	meta.string("en")
+	meta.string("Created by PPIO at " + mk.buildTime.String())
+	meta.string("ip_version")
+	meta.uint(mmdb.Uint16, uint64(ipVersion))
+	meta.string("languages")
+	meta.control(mmdb.Array, 1)
+	meta.string("zh-CN")
+	meta.string("node_count")
+	meta.uint(mmdb.Uint32, uint64(nodeCount))
+	meta.string("record_size")
+	meta.uint(mmdb.Uint16, uint64(recordSize))
+
+	if _, err := w.WriteString(mmdb.MetadataStart); err != nil {
+		return err
+	}
+	_, err := w.Write(meta.b)
+	return err
+}
+
+// unknown reports whether none of the region fields is known.
+func (dbl *DateBlock) unknown() bool {
+	for _, s := range []string{dbl.country, dbl.province, dbl.city, dbl.isp} {
+		if s != "" && s != "0" {
+			return false
+		}
+	}
+	return true
+}
+
+// mmdbNode is a node of the search tree, each side holds either a child
+// node, a data record as its offset in the data section plus one, or
+// nothing.
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  [2]int
+}
+
+type mmdbTree struct {
+	root *mmdbNode
+}
+
+func keyBit(key []byte, i int) int {
+	return int(key[i/8]>>(7-i%8)) & 1
+}
+
+// insert stores data for the network of the first bits bits of key,
+// replacing whatever overlapped it.
+func (t *mmdbTree) insert(key []byte, bits, data int) {
+	if bits == 0 {
+		// the root is always a node, store both halves
+		t.insert(key, 1, data)
+		other := append([]byte(nil), key...)
+		other[0] ^= 0x80
+		t.insert(other, 1, data)
+		return
+	}
+	n := t.root
+	for i := 0; i < bits-1; i++ {
+		bit := keyBit(key, i)
+		if n.child[bit] == nil {
+			// split the larger network stored here, if any
+			d := n.data[bit]
+			n.child[bit] = &mmdbNode{data: [2]int{d, d}}
+			n.data[bit] = 0
+		}
+		n = n.child[bit]
+	}
+	bit := keyBit(key, bits-1)
+	n.child[bit], n.data[bit] = nil, data
+}
+
+// alias points the network of the first bits bits of key at the ipv4
+// subtree ::/96, unless the network already holds data.
+func (t *mmdbTree) alias(key []byte, bits int) {
+	n := t.root
+	for i := 0; i < 95; i++ {
+		if n = n.child[0]; n == nil {
+			return
+		}
+	}
+	child, data := n.child[0], n.data[0]
+	if child == nil && data == 0 {
+		return
+	}
+
+	n = t.root
+	for i := 0; i < bits-1; i++ {
+		bit := keyBit(key, i)
+		if n.child[bit] == nil {
+			if n.data[bit] != 0 {
+				return
+			}
+			n.child[bit] = &mmdbNode{}
+		}
+		n = n.child[bit]
+	}
+	bit := keyBit(key, bits-1)
+	if n.child[bit] != nil || n.data[bit] != 0 {
+		return
+	}
+	n.child[bit], n.data[bit] = child, data
+}
+
+// nodes numbers the nodes breadth first, the root is node 0. Aliased
+// subtrees are numbered once.
+func (t *mmdbTree) nodes() ([]*mmdbNode, map[*mmdbNode]int) {
+	nodes := []*mmdbNode{t.root}
+	index := map[*mmdbNode]int{t.root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].child {
+			if _, ok := index[c]; c != nil && !ok {
+				index[c] = len(nodes)
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	return nodes, index
+}
+
+// mmdbEncoder encodes values of the MMDB data section.
+type mmdbEncoder struct {
+	b []byte
+}
+
+func (e *mmdbEncoder) control(typ, size int) {
+	ctrl := byte(typ << 5)
+	if typ > 7 {
+		ctrl = 0
+	}
+	var ext []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		ext = []byte{byte(size - 29)}
+	case size < 65821:
+		ctrl |= 30
+		size -= 285
+		ext = []byte{byte(size >> 8), byte(size)}
+	default:
+		ctrl |= 31
+		size -= 65821
+		ext = []byte{byte(size >> 16), byte(size >> 8), byte(size)}
+	}
+	e.b = append(e.b, ctrl)
+	if typ > 7 {
+		e.b = append(e.b, byte(typ-7))
+	}
+	e.b = append(e.b, ext...)
+}
+
+func (e *mmdbEncoder) string(s string) {
+	e.control(mmdb.String, len(s))
+	e.b = append(e.b, s...)
+}
+
+// uint writes v in as few bytes as it needs.
+func (e *mmdbEncoder) uint(typ int, v uint64) {
+	n := 0
+	for x := v; x > 0; x >>= 8 {
+		n++
+	}
+	e.control(typ, n)
+	for i := n - 1; i >= 0; i-- {
+		e.b = append(e.b, byte(v>>(8*i)))
+	}
+}
+
+// region writes the data record of a region.
+func (e *mmdbEncoder) region(dbl DateBlock) {
+	strs := [][2]string{{"country", dbl.country}, {"province", dbl.province}, {"city", dbl.city}, {"isp", dbl.isp}}
+	idKeys := []string{"region_id", "province_id", "isp_id"}
+	ids := []int{dbl.regionId, dbl.provinceId, dbl.ispId}
+
+	n := 0
+	for _, f := range strs {
+		if f[1] != "" && f[1] != "0" {
+			n++
+		}
+	}
+	for _, id := range ids {
+		if id > 0 {
+			n++
+		}
+	}
+	e.control(mmdb.Map, n)
+	for _, f := range strs {
+		if f[1] != "" && f[1] != "0" {
+			e.string(f[0])
+			e.string(f[1])
+		}
+	}
+	for i, id := range ids {
+		if id > 0 {
+			e.string(idKeys[i])
+			e.uint(mmdb.Uint32, uint64(id))
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package maker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	ip2region "github.com/hokitlee/go-ip2region/query"
+)
+
+/**
+ * upstream ip2region xdb (v2) writer
+ * <p>
+ * +-----------+---------------+-----------+-------------------+
+ * | header	| vector index	| regions	| segment index		|
+ * +-----------+---------------+-----------+-------------------+
+ * <p>
+ * header: version(2)|index policy(2)|created at(4)|start index ptr(4)|end index ptr(4)
+ * padded to 256 bytes.
+ * <p>
+ * vector index: 256*256 entries of start ptr(4)|end ptr(4), the segment
+ * index blocks of every /16, the end ptr is exclusive.
+ * <p>
+ * segment index: start ip(4)|end ip(4)|data length(2)|data ptr(4), the
+ * ranges are split at /16 boundaries.
+ * <p>
+ * every integer is little endian, the regions are 国家|区域|省份|城市|ISP.
+ */
+
+// the layout is defined by the query package, which reads xdb files
+const (
+	// XdbVersion is the version of the xdb files written by WithXdb.
+	XdbVersion = ip2region.XdbVersion
+
+	// regions longer than the uint16 data length do not fit
+	xdbMaxRegionLength = 0xFFFF
+)
+
+// WithXdb makes Make write an upstream ip2region xdb (v2) file instead of
+// a db, for the upstream searchers and the query package to read. Xdb
+// files hold neither IPv6 ranges, which are left out, nor ids, which
+// searchers look up in code tables, nor a META section.
+func WithXdb() Option {
+	return func(mk *Maker) {
+		mk.xdb = true
+	}
+}
+
+// writeXdbFile writes the IPv4 ranges of the db as an xdb file at path.
+func (mk *Maker) writeXdbFile(path string) error {
+	b, err := mk.xdbBytes()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (mk *Maker) xdbBytes() ([]byte, error) {
+	type region struct {
+		ptr, length int
+	}
+	buf := make([]byte, ip2region.XdbHeaderLength+ip2region.XdbVectorIndexSize)
+	regions := make(map[string]region)
+	var index []byte
+	ipv6 := 0
+
+	for _, md := range mk.metadata {
+		if IsIPv6(md.StartIP) {
+			ipv6++
+			continue
+		}
+		start, err := IpString2Int64(md.StartIP)
+		if err != nil {
+			return nil, err
+		}
+		end, err := IpString2Int64(md.EndIP)
+		if err != nil {
+			return nil, err
+		}
+
+		dataBlock := mk.dataBlock(md)
+		s := dataBlock.upstreamRegion()
+		r, ok := regions[s]
+		if !ok {
+			if len(s) > xdbMaxRegionLength {
+				return nil, fmt.Errorf("xdb region of %s is %d bytes, longer than %d", md.String(), len(s), xdbMaxRegionLength)
+			}
+			r = region{ptr: len(buf), length: len(s)}
+			regions[s] = r
+			buf = append(buf, s...)
+		}
+
+		// split at /16 boundaries for the vector index
+		for {
+			segEnd := start | 0xFFFF
+			if segEnd > end {
+				segEnd = end
+			}
+			b := make([]byte, ip2region.XdbSegmentIndexLength)
+			binary.LittleEndian.PutUint32(b, uint32(start))
+			binary.LittleEndian.PutUint32(b[4:], uint32(segEnd))
+			binary.LittleEndian.PutUint16(b[8:], uint16(r.length))
+			binary.LittleEndian.PutUint32(b[10:], uint32(r.ptr))
+			index = append(index, b...)
+			if segEnd == end {
+				break
+			}
+			start = segEnd + 1
+		}
+	}
+	if ipv6 > 0 {
+		log.Printf("+- %d ipv6 ranges left out of the xdb \n", ipv6)
+	}
+	if len(index) == 0 {
+		return nil, errors.New("xdb: no ipv4 ranges")
+	}
+
+	indexStartPtr := len(buf)
+	for off := 0; off < len(index); off += ip2region.XdbSegmentIndexLength {
+		ptr := uint32(indexStartPtr + off)
+		p := ip2region.XdbHeaderLength + int(binary.LittleEndian.Uint32(index[off:])>>16)*ip2region.XdbVectorIndexLength
+		if binary.LittleEndian.Uint32(buf[p:]) == 0 {
+			binary.LittleEndian.PutUint32(buf[p:], ptr)
+		}
+		binary.LittleEndian.PutUint32(buf[p+4:], ptr+ip2region.XdbSegmentIndexLength)
+	}
+	buf = append(buf, index...)
+
+	binary.LittleEndian.PutUint16(buf, XdbVersion)
+	binary.LittleEndian.PutUint16(buf[2:], ip2region.XdbVectorIndexPolicy)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(mk.buildTime.Unix()))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(indexStartPtr))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(buf)-ip2region.XdbSegmentIndexLength))
+	return buf, nil
+}
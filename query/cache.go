@@ -0,0 +1,241 @@
+package ip2region
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// RangeSearcher is a Searcher that can also return the matched range, it
+// is implemented by *Ip2Region and *Reloader.
+type RangeSearcher interface {
+	Searcher
+	SearchAddrRange(addr netip.Addr) (Range, error)
+}
+
+var (
+	_ RangeSearcher = (*Ip2Region)(nil)
+	_ RangeSearcher = (*Reloader)(nil)
+	_ RangeSearcher = (*Cache)(nil)
+)
+
+// cacheShards is the most independently locked LRU lists the entries of
+// a Cache are spread over, by the /16 of IPv4 and the /32 of IPv6
+// addresses, to keep lock contention low.
+const cacheShards = 16
+
+// Cache is a size bounded, concurrency safe LRU cache of search results in
+// front of a RangeSearcher, for traffic that keeps coming from the same
+// networks. Results are cached per matched range, so one search serves
+// every address of the range; not found results are cached for their
+// address alone and other errors are not cached.
+//
+// The cache does not notice when the db changes, call Purge after
+// reloading the searcher it wraps.
+type Cache struct {
+	s      RangeSearcher
+	shards []cacheShard
+
+	hits   uint64
+	misses uint64
+}
+
+type cacheShard struct {
+	mu   sync.Mutex
+	size int
+	ll   *list.List
+	// the elements of ll by the start of their range
+	starts []*list.Element
+}
+
+// cacheEntry is a cached result for the addresses from start to end.
+type cacheEntry struct {
+	start, end netip.Addr
+	r          Range
+	err        error
+}
+
+func entryOf(e *list.Element) *cacheEntry {
+	return e.Value.(*cacheEntry)
+}
+
+// CacheStats are the counters of a Cache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Len is the number of cached ranges.
+	Len int
+}
+
+// NewCache returns a Cache of at most size results in front of s, a size
+// below 1 is taken as 1.
+func NewCache(s RangeSearcher, size int) *Cache {
+	if size < 1 {
+		size = 1
+	}
+	n := cacheShards
+	if size < n {
+		n = size
+	}
+	c := &Cache{s: s, shards: make([]cacheShard, n)}
+	for i := range c.shards {
+		// size split exactly, the first shards take the remainder
+		per := size / n
+		if i < size%n {
+			per++
+		}
+		c.shards[i] = cacheShard{
+			size: per,
+			ll:   list.New(),
+		}
+	}
+	return c
+}
+
+// Search searches an ip string through the cache.
+func (c *Cache) Search(ipStr string) (IpInfo, error) {
+	addr, err := parseAddr(ipStr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	r, err := c.SearchAddrRange(addr)
+	return r.IpInfo, err
+}
+
+// SearchAddr searches addr through the cache.
+func (c *Cache) SearchAddr(addr netip.Addr) (IpInfo, error) {
+	r, err := c.SearchAddrRange(addr)
+	return r.IpInfo, err
+}
+
+// SearchAddrRange searches addr through the cache and returns the matched
+// range.
+func (c *Cache) SearchAddrRange(addr netip.Addr) (Range, error) {
+	addr = addr.Unmap()
+	sh := &c.shards[c.shardOf(addr)]
+
+	sh.mu.Lock()
+	if e := sh.find(addr); e != nil {
+		sh.ll.MoveToFront(e)
+		ent := entryOf(e)
+		sh.mu.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return ent.r, ent.err
+	}
+	sh.mu.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	r, err := c.s.SearchAddrRange(addr)
+	ent := &cacheEntry{start: r.Start, end: r.End, r: r, err: err}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		ent.start, ent.end = addr, addr
+	case err != nil:
+		return r, err
+	}
+
+	sh.mu.Lock()
+	if e := sh.find(addr); e != nil {
+		// added by a concurrent miss
+		sh.ll.MoveToFront(e)
+	} else {
+		sh.insert(sh.ll.PushFront(ent))
+		if sh.ll.Len() > sh.size {
+			sh.remove(sh.ll.Back())
+		}
+	}
+	sh.mu.Unlock()
+	return r, err
+}
+
+// search returns the index of the first element of sh.starts whose range
+// starts after addr.
+func (sh *cacheShard) search(addr netip.Addr) int {
+	return sort.Search(len(sh.starts), func(i int) bool {
+		return addr.Less(entryOf(sh.starts[i]).start)
+	})
+}
+
+// find returns the element whose range holds addr, or nil.
+func (sh *cacheShard) find(addr netip.Addr) *list.Element {
+	i := sh.search(addr) - 1
+	if i < 0 {
+		return nil
+	}
+	e := sh.starts[i]
+	if ent := entryOf(e); ent.start.BitLen() != addr.BitLen() || ent.end.Less(addr) {
+		return nil
+	}
+	return e
+}
+
+func (sh *cacheShard) insert(e *list.Element) {
+	i := sh.search(entryOf(e).start)
+	sh.starts = append(sh.starts, nil)
+	copy(sh.starts[i+1:], sh.starts[i:])
+	sh.starts[i] = e
+}
+
+// remove drops e from ll and starts, ranges of a db reloaded without Purge
+// may share a start with it.
+func (sh *cacheShard) remove(e *list.Element) {
+	sh.ll.Remove(e)
+	for i := sh.search(entryOf(e).start) - 1; i >= 0; i-- {
+		if sh.starts[i] == e {
+			sh.starts = append(sh.starts[:i], sh.starts[i+1:]...)
+			return
+		}
+	}
+}
+
+// shardOf picks the shard of the /16 of an IPv4 and the /32 of an IPv6
+// address, so that the addresses of a range within one of them share the
+// cached entry. The network is hashed to spread adjacent ones evenly.
+func (c *Cache) shardOf(addr netip.Addr) int {
+	var network uint32
+	if addr.Is4() {
+		b := addr.As4()
+		network = uint32(binary.BigEndian.Uint16(b[:2]))
+	} else {
+		b := addr.As16()
+		network = binary.BigEndian.Uint32(b[:4])
+	}
+	// fibonacci hashing, the product is scaled to the number of shards
+	h := network * 0x9E3779B1
+	return int(uint64(h) * uint64(len(c.shards)) >> 32)
+}
+
+// Stats returns the hit and miss counters and the number of cached ranges.
+func (c *Cache) Stats() CacheStats {
+	st := CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		st.Len += sh.ll.Len()
+		sh.mu.Unlock()
+	}
+	return st
+}
+
+// Purge drops every cached result, the counters are kept.
+func (c *Cache) Purge() {
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		sh.ll.Init()
+		sh.starts = nil
+		sh.mu.Unlock()
+	}
+}
+
+// Close closes the wrapped searcher.
+func (c *Cache) Close() error {
+	return c.s.Close()
+}
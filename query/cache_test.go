@@ -0,0 +1,186 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	region, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := NewCache(region, 64)
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		want, _ := ipr.BtreeSearch("1.2.3.4")
+		got, err := c.Search("1.2.3.4")
+		if err != nil || got != want {
+			t.Fatalf("Search = %v, %v; want %v", got, err, want)
+		}
+	}
+	if _, err := c.Search("::ffff:1.2.3.4"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if st := c.Stats(); st.Hits != 2 || st.Misses != 1 || st.Len != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	if _, err := c.Search("1.2.3.x"); !errors.Is(err, ErrInvalidIP) {
+		t.Fatalf("expected ErrInvalidIP, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Search("2001:db8::1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if st := c.Stats(); st.Hits != 3 || st.Misses != 2 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	for i := 0; i < 1000; i++ {
+		c.SearchAddr(long2Addr(rand.Int63n(1 << 32)))
+	}
+	if st := c.Stats(); st.Len > 64 {
+		t.Fatalf("cache holds %d entries", st.Len)
+	}
+	c.Purge()
+	if st := c.Stats(); st.Len != 0 {
+		t.Fatalf("cache holds %d entries after Purge", st.Len)
+	}
+}
+
+func TestCache_ranges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	// one range per /16
+	if err := writeTestDB(path, testRecords(1<<16), testDBOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	region, err := New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := NewCache(region, 1)
+	defer c.Close()
+
+	search := func(ip, start string) {
+		t.Helper()
+		r, err := c.SearchAddrRange(netip.MustParseAddr(ip))
+		if err != nil || r.Start.String() != start {
+			t.Fatalf("%s: got %+v, %v, want the range at %s", ip, r, err, start)
+		}
+	}
+	for _, ip := range []string{"1.2.3.4", "1.2.0.0", "1.2.200.1", "1.2.255.255"} {
+		search(ip, "1.2.0.0")
+	}
+	if st := c.Stats(); st.Hits != 3 || st.Misses != 1 || st.Len != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+	// the only entry is evicted by the next range
+	search("1.3.0.1", "1.3.0.0")
+	search("1.2.9.9", "1.2.0.0")
+	if st := c.Stats(); st.Hits != 3 || st.Misses != 3 || st.Len != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestCache_size(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	if err := writeTestDB(path, testRecords(1<<16), testDBOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	region, err := New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer region.Close()
+
+	for _, size := range []int{1, 5, 16, 100} {
+		c := NewCache(region, size)
+		for i := 0; i < 2000; i++ {
+			c.SearchAddr(long2Addr(rand.Int63n(1 << 32)))
+		}
+		if st := c.Stats(); st.Len != size {
+			t.Errorf("NewCache(%d) holds %d ranges", size, st.Len)
+		}
+	}
+
+	// adjacent networks spread over the shards
+	c := NewCache(region, 1024)
+	shards := make(map[int]bool)
+	for i := int64(0); i < cacheShards; i++ {
+		shards[c.shardOf(long2Addr(0x01000000+i<<16))] = true
+	}
+	if len(shards) < cacheShards*3/4 {
+		t.Errorf("1.0.0.0-1.15.255.255 fall in %d shards", len(shards))
+	}
+}
+
+func TestCache_concurrent(t *testing.T) {
+	region, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := NewCache(region, 128)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				addr := long2Addr(rnd.Int63n(256) << 24)
+				want, _ := ipr.SearchAddrRange(addr)
+				got, err := c.SearchAddrRange(addr)
+				if err != nil || got.Start != want.Start || got.IpInfo != want.IpInfo {
+					t.Errorf("SearchAddrRange(%v) = %v, %v; want %v", addr, got, err, want)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	st := c.Stats()
+	if st.Hits+st.Misses != 8*2000 || st.Hits == 0 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func BenchmarkCacheSearch(B *testing.B) {
+	region, err := New(dbPath)
+	if err != nil {
+		B.Fatalf("%v", err)
+	}
+	c := NewCache(region, 1024)
+	defer c.Close()
+	addrs := make([]netip.Addr, 512)
+	for i := range addrs {
+		addrs[i] = long2Addr(rand.Int63n(1 << 32))
+	}
+	B.ResetTimer()
+	for i := 0; i < B.N; i++ {
+		if _, err := c.SearchAddr(addrs[i%len(addrs)]); err != nil {
+			B.Fatalf("%v", err)
+		}
+	}
+}
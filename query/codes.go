@@ -0,0 +1,64 @@
+package ip2region
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hokitlee/go-ip2region/internal/codes"
+)
+
+// CodeTables map region names to the ids of IpInfo, for dbs whose data
+// blocks carry no ids such as upstream v1 dbs.
+type CodeTables struct {
+	RegionIds   map[string]int64
+	ProvinceIds map[string]int64
+	ISPIds      map[string]int64
+}
+
+// ReadCodeTables reads the area code table, "regionId,provinceId,name"
+// per line like data/area_code.csv, and the isp code table, "code,ispId,
+// name" per line like data/isp_code.csv. Either reader may be nil.
+func ReadCodeTables(area, isp io.Reader) (*CodeTables, error) {
+	t := &CodeTables{
+		RegionIds:   make(map[string]int64),
+		ProvinceIds: make(map[string]int64),
+		ISPIds:      make(map[string]int64),
+	}
+	if area != nil {
+		err := codes.ReadTable(area, func(a, b int64, name string) {
+			t.RegionIds[name] = a
+			t.ProvinceIds[name] = b
+		})
+		if err != nil {
+			return nil, fmt.Errorf("area %v", err)
+		}
+	}
+	if isp != nil {
+		err := codes.ReadTable(isp, func(_, b int64, name string) {
+			t.ISPIds[name] = b
+		})
+		if err != nil {
+			return nil, fmt.Errorf("isp %v", err)
+		}
+	}
+	return t, nil
+}
+
+// fill sets the ids of info from its province and isp names, t may be nil.
+func (t *CodeTables) fill(info *IpInfo) {
+	if t == nil {
+		return
+	}
+	province := t.province(info.Province)
+	info.RegionId = t.RegionIds[province]
+	info.ProvinceId = t.ProvinceIds[province]
+	info.ISPId = t.ISPIds[info.ISP]
+}
+
+// province returns the name the code tables use for province.
+func (t *CodeTables) province(province string) string {
+	return codes.Province(province, func(name string) bool {
+		_, ok := t.ProvinceIds[name]
+		return ok
+	})
+}
@@ -0,0 +1,58 @@
+package ip2region
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrNotFound is returned when no range of the db contains the ip.
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidIP is matched by the *ParseError returned for malformed ip
+	// strings and addresses.
+	ErrInvalidIP = errors.New("ip format error")
+
+	// ErrCorruptDB is matched by the errors returned when the super block,
+	// header blocks, index or data blocks point outside the db or are
+	// inconsistent, typically because the file was truncated.
+	ErrCorruptDB = errors.New("corrupt db")
+
+	// ErrUnsupportedFormat is matched by the error returned for dbs written
+	// in a newer format than FormatVersion.
+	ErrUnsupportedFormat = errors.New("unsupported db format")
+)
+
+func (e *ParseError) Unwrap() error {
+	return ErrInvalidIP
+}
+
+func corruptf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrCorruptDB}, args...)...)
+}
+
+// readAt fills b from the db at off, what names the part being read
+// for the error. Reading past the end of the file means a pointer in the
+// db is wrong, so it is reported as ErrCorruptDB; other I/O errors are
+// wrapped and can be tested with errors.Is.
+func (ipr *Ip2Region) readAt(b []byte, off int64, what string) error {
+	return readFullAt(ipr.reader, b, off, what)
+}
+
+// readFullAt reads len(b) bytes at off from r, reporting a short read as
+// ErrCorruptDB.
+func readFullAt(r io.ReaderAt, b []byte, off int64, what string) error {
+	n, err := r.ReadAt(b, off)
+	if err == io.EOF && n == len(b) {
+		// allowed at the end of the input by io.ReaderAt
+		err = nil
+	}
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return corruptf("%s at %d is past the end of the db", what, off)
+		}
+		return fmt.Errorf("ip2region: read %s at %d: %w", what, off, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	records := []testRecord{
+		{startIP: 0x01000000, endIP: 0x01ffffff, info: "中国|北京|北京市|联通|1|11|2"},
+		{startIP: 0x03000000, endIP: 0x03ffffff, info: "美国|0|0|0|0|0|0"},
+	}
+	path := filepath.Join(dir, "ip.db")
+	if err := writeTestDB(path, records, testDBOptions{vector: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	searches := func(region *Ip2Region) map[string]func(string) (IpInfo, error) {
+		return map[string]func(string) (IpInfo, error){
+			"memory": region.MemorySearch,
+			"binary": region.BinarySearch,
+			"btree":  region.BtreeSearch,
+		}
+	}
+
+	region, err := New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name, search := range searches(region) {
+		for _, c := range []struct {
+			ip   string
+			want error
+		}{
+			{"2.0.0.1", ErrNotFound},
+			{"200.0.0.1", ErrNotFound},
+			{"2001:db8::1", ErrNotFound},
+			{"1.2.3.x", ErrInvalidIP},
+		} {
+			if _, err := search(c.ip); !errors.Is(err, c.want) {
+				t.Errorf("%s(%s) = %v, want %v", name, c.ip, err, c.want)
+			}
+		}
+	}
+	region.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// a data pointer past the end of the file
+	corrupt := append([]byte(nil), b...)
+	writeIntLong(corrupt, int(GetLong(corrupt, 0))+8, int64(len(corrupt))|10<<24)
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	region, err = New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name, search := range searches(region) {
+		if _, err := search("1.2.3.4"); !errors.Is(err, ErrCorruptDB) {
+			t.Errorf("%s = %v, want ErrCorruptDB", name, err)
+		}
+	}
+	region.Close()
+
+	// truncated inside the index
+	if err := ioutil.WriteFile(path, b[:GetLong(b, 4)], 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := New(path); !errors.Is(err, ErrCorruptDB) {
+		t.Errorf("New of a truncated db = %v, want ErrCorruptDB", err)
+	}
+
+	// truncated inside the header
+	if err := ioutil.WriteFile(path, b[:100], 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := New(path); !errors.Is(err, ErrCorruptDB) {
+		t.Errorf("New of a truncated header = %v, want ErrCorruptDB", err)
+	}
+}
@@ -0,0 +1,160 @@
+package ip2region
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExportFormat is an output format of RangeWriter.
+type ExportFormat int
+
+const (
+	// ExportCSV writes comma separated values with a header row, the
+	// CIDR prefixes of a range are separated by spaces.
+	ExportCSV ExportFormat = iota
+	// ExportTSV is ExportCSV with tabs.
+	ExportTSV
+	// ExportJSONLines writes one JSON object per range.
+	ExportJSONLines
+)
+
+var exportFormatNames = []string{"csv", "tsv", "jsonl"}
+
+func (f ExportFormat) String() string {
+	if f >= 0 && int(f) < len(exportFormatNames) {
+		return exportFormatNames[f]
+	}
+	return "ExportFormat(" + strconv.Itoa(int(f)) + ")"
+}
+
+// ParseExportFormat returns the format named "csv", "tsv" or "jsonl".
+func ParseExportFormat(name string) (ExportFormat, error) {
+	for i, n := range exportFormatNames {
+		if n == name {
+			return ExportFormat(i), nil
+		}
+	}
+	return 0, fmt.Errorf("ip2region: unknown export format %q", name)
+}
+
+// ExportColumns are the columns written by ExportCSV and ExportTSV, and
+// the keys of the ExportJSONLines objects.
+var ExportColumns = []string{"start_ip", "end_ip", "cidrs", "country", "province", "city", "isp", "region_id", "province_id", "isp_id"}
+
+// exportRecord is a range as written by ExportJSONLines.
+type exportRecord struct {
+	StartIP    string   `json:"start_ip"`
+	EndIP      string   `json:"end_ip"`
+	CIDRs      []string `json:"cidrs"`
+	Country    string   `json:"country"`
+	Province   string   `json:"province"`
+	City       string   `json:"city"`
+	ISP        string   `json:"isp"`
+	RegionId   int64    `json:"region_id"`
+	ProvinceId int64    `json:"province_id"`
+	ISPId      int64    `json:"isp_id"`
+}
+
+// RangeWriter writes ranges in an ExportFormat. Call Flush after the last
+// Write.
+type RangeWriter struct {
+	format ExportFormat
+	bw     *bufio.Writer
+	cw     *csv.Writer
+	enc    *json.Encoder
+	header bool
+}
+
+// NewRangeWriter returns a RangeWriter writing format to w.
+func NewRangeWriter(w io.Writer, format ExportFormat) (*RangeWriter, error) {
+	rw := &RangeWriter{format: format, bw: bufio.NewWriter(w)}
+	switch format {
+	case ExportCSV, ExportTSV:
+		rw.cw = csv.NewWriter(rw.bw)
+		if format == ExportTSV {
+			rw.cw.Comma = '\t'
+		}
+		rw.header = true
+	case ExportJSONLines:
+		rw.enc = json.NewEncoder(rw.bw)
+		rw.enc.SetEscapeHTML(false)
+	default:
+		return nil, fmt.Errorf("ip2region: unknown export format %v", format)
+	}
+	return rw, nil
+}
+
+// Write writes one range.
+func (rw *RangeWriter) Write(r Range) error {
+	prefixes := r.Prefixes()
+	cidrs := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		cidrs[i] = p.String()
+	}
+
+	if rw.enc != nil {
+		return rw.enc.Encode(exportRecord{
+			StartIP:    r.Start.String(),
+			EndIP:      r.End.String(),
+			CIDRs:      cidrs,
+			Country:    r.Country,
+			Province:   r.Province,
+			City:       r.City,
+			ISP:        r.ISP,
+			RegionId:   r.RegionId,
+			ProvinceId: r.ProvinceId,
+			ISPId:      r.ISPId,
+		})
+	}
+
+	if err := rw.writeHeader(); err != nil {
+		return err
+	}
+	return rw.cw.Write([]string{
+		r.Start.String(), r.End.String(), strings.Join(cidrs, " "),
+		r.Country, r.Province, r.City, r.ISP,
+		strconv.FormatInt(r.RegionId, 10), strconv.FormatInt(r.ProvinceId, 10), strconv.FormatInt(r.ISPId, 10),
+	})
+}
+
+// writeHeader writes the header row before the first row.
+func (rw *RangeWriter) writeHeader() error {
+	if !rw.header {
+		return nil
+	}
+	rw.header = false
+	return rw.cw.Write(ExportColumns)
+}
+
+// Flush writes any buffered data to the underlying writer, and the header
+// row if no range was written.
+func (rw *RangeWriter) Flush() error {
+	if rw.cw != nil {
+		if err := rw.writeHeader(); err != nil {
+			return err
+		}
+		rw.cw.Flush()
+		if err := rw.cw.Error(); err != nil {
+			return err
+		}
+	}
+	return rw.bw.Flush()
+}
+
+// Export writes every range of the db to w in format, in the order of
+// Iterate.
+func (ipr *Ip2Region) Export(w io.Writer, format ExportFormat) error {
+	rw, err := NewRangeWriter(w, format)
+	if err != nil {
+		return err
+	}
+	if err := ipr.Iterate(rw.Write); err != nil {
+		return err
+	}
+	return rw.Flush()
+}
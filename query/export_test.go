@@ -0,0 +1,103 @@
+package ip2region
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIp2Region_Export(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	records := []testRecord{
+		{0, 0xFFFFFF, "0|0|0|内网IP|0|0|0"},
+		{0x01000000, 0x01000005, "中国|广东|深圳市|电信|440000|44|3"},
+		{0x01000006, 0xFFFFFFFF, "美国|0|0|0|0|0|0"},
+	}
+	ipv6 := []testRecord6{{"2001:db8::", "2001:db8::ffff", "中国|北京|北京市|联通|110000|11|2"}}
+	if err := writeTestDB(path, records, testDBOptions{ipv6: ipv6}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	region, err := New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer region.Close()
+
+	var buf bytes.Buffer
+	if err := region.Export(&buf, ExportCSV); err != nil {
+		t.Fatalf("%v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(rows) != 5 || strings.Join(rows[0], ",") != strings.Join(ExportColumns, ",") {
+		t.Fatalf("rows %q", rows)
+	}
+	want := []string{"1.0.0.0", "1.0.0.5", "1.0.0.0/30 1.0.0.4/31", "中国", "广东", "深圳市", "电信", "440000", "44", "3"}
+	if strings.Join(rows[2], ",") != strings.Join(want, ",") {
+		t.Fatalf("got %q, want %q", rows[2], want)
+	}
+
+	buf.Reset()
+	if err := region.Export(&buf, ExportTSV); err != nil {
+		t.Fatalf("%v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || lines[4] != "2001:db8::\t2001:db8::ffff\t2001:db8::/112\t中国\t北京\t北京市\t联通\t110000\t11\t2" {
+		t.Fatalf("got %q", lines)
+	}
+
+	buf.Reset()
+	if err := region.Export(&buf, ExportJSONLines); err != nil {
+		t.Fatalf("%v", err)
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %q", lines)
+	}
+	var rec map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, col := range ExportColumns {
+		if _, ok := rec[col]; !ok {
+			t.Fatalf("%s missing from %s", col, lines[0])
+		}
+	}
+	if rec["isp"] != "内网IP" || rec["cidrs"].([]interface{})[0] != "0.0.0.0/8" {
+		t.Fatalf("got %s", lines[0])
+	}
+
+	for _, f := range []ExportFormat{ExportCSV, ExportTSV, ExportJSONLines} {
+		if got, err := ParseExportFormat(f.String()); err != nil || got != f {
+			t.Errorf("ParseExportFormat(%q) = %v, %v", f.String(), got, err)
+		}
+	}
+	for _, f := range []ExportFormat{ExportCSV, ExportTSV} {
+		buf.Reset()
+		rw, err := NewRangeWriter(&buf, f)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := rw.Flush(); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := strings.Count(buf.String(), "\n"); got != 1 || !strings.HasPrefix(buf.String(), ExportColumns[0]) {
+			t.Fatalf("%v: empty export got %q", f, buf.String())
+		}
+	}
+	if _, err := NewRangeWriter(&buf, ExportFormat(9)); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,373 @@
+package ip2region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	"github.com/hokitlee/go-ip2region/internal/mmdb"
+)
+
+// testRecord is one ip range of a test db, info is the raw data block.
+type testRecord struct {
+	startIP int64
+	endIP   int64
+	info    string
+}
+
+// testRecords splits the whole IPv4 space into n equal ranges which cycle
+// through a handful of regions.
+func testRecords(n int) []testRecord {
+	regions := []string{
+		"中国|北京|北京市|联通|1|11|2",
+		"中国|广东|深圳市|电信|3|44|3",
+		"中国|浙江|杭州市|移动|2|33|1",
+		"美国|0|0|0|0|0|0",
+		"0|0|0|内网IP|0|0|0",
+	}
+	size := (int64(1) << 32) / int64(n)
+	rs := make([]testRecord, 0, n)
+	for i := 0; i < n; i++ {
+		rs = append(rs, testRecord{
+			startIP: int64(i) * size,
+			endIP:   int64(i+1)*size - 1,
+			info:    regions[i%len(regions)],
+		})
+	}
+	return rs
+}
+
+// testRecord6 is one IPv6 range of a test db.
+type testRecord6 struct {
+	startIP string
+	endIP   string
+	info    string
+}
+
+type testDBOptions struct {
+	vector bool
+	ipv6   []testRecord6
+	// meta is written with the checksum of the db unless it has one
+	meta *Metadata
+}
+
+// writeTestDB writes records to path in the same layout the maker uses:
+// super block, header blocks, data blocks, index blocks, the sections
+// enabled in opt and a trailer.
+func writeTestDB(path string, records []testRecord, opt testDBOptions) error {
+	const headerSize, indexBlockSize = 8 * 2048, 4 * 2048
+
+	buf := make([]byte, 8+headerSize)
+	dataPtrs := make(map[string]int64)
+	dataPtr := func(info string) int64 {
+		ptr, ok := dataPtrs[info]
+		if !ok {
+			ptr = int64(len(buf)) | int64(len(info))<<24
+			dataPtrs[info] = ptr
+			buf = append(buf, info...)
+		}
+		return ptr
+	}
+
+	index6 := make([]byte, 0, len(opt.ipv6)*IndexBlock6Length)
+	for _, r := range opt.ipv6 {
+		b := make([]byte, IndexBlock6Length)
+		copy(b, net.ParseIP(r.startIP).To16())
+		copy(b[16:], net.ParseIP(r.endIP).To16())
+		writeIntLong(b, 32, dataPtr(r.info))
+		index6 = append(index6, b...)
+	}
+
+	index := make([]byte, 0, len(records)*IndexBlockLength)
+	for _, r := range records {
+		ptr := dataPtr(r.info)
+		b := make([]byte, IndexBlockLength)
+		writeIntLong(b, 0, r.startIP)
+		writeIntLong(b, 4, r.endIP)
+		writeIntLong(b, 8, ptr)
+		index = append(index, b...)
+	}
+
+	firstIndexPtr := int64(len(buf))
+	buf = append(buf, index...)
+	lastIndexPtr := int64(len(buf)) - IndexBlockLength
+	writeIntLong(buf, 0, firstIndexPtr)
+	writeIntLong(buf, 4, lastIndexPtr)
+
+	perBlock := indexBlockSize/IndexBlockLength - 1
+	var headers []int64
+	for i := 0; i < len(records); i += perBlock {
+		headers = append(headers, records[i].startIP, firstIndexPtr+int64(i)*IndexBlockLength)
+	}
+	if len(records) > 0 {
+		headers = append(headers, records[len(records)-1].startIP, lastIndexPtr+IndexBlockLength)
+	}
+	for i, v := range headers {
+		writeIntLong(buf, 8+i*4, v)
+	}
+
+	if opt.vector {
+		vector := make([]byte, VectorIndexSize)
+		for i, r := range records {
+			ptr := firstIndexPtr + int64(i)*IndexBlockLength
+			for k := r.startIP >> 16; k <= r.endIP>>16; k++ {
+				if GetLong(vector, k*VectorIndexLength) == 0 {
+					writeIntLong(vector, int(k*VectorIndexLength), ptr)
+				}
+				writeIntLong(vector, int(k*VectorIndexLength+4), ptr)
+			}
+		}
+		buf = appendSection(buf, SectionVectorIndex, vector)
+	}
+
+	if len(opt.ipv6) > 0 {
+		buf = appendSection(buf, SectionIPv6Index, index6)
+	}
+
+	if opt.meta != nil {
+		md := *opt.meta
+		if md.Checksum == "" {
+			md.Checksum, _ = checksum(bytes.NewReader(buf))
+		}
+		b, err := json.Marshal(md)
+		if err != nil {
+			return err
+		}
+		buf = appendSection(buf, SectionMetadata, b)
+	}
+
+	buf = append(buf, "Created by test at "+strconv.Itoa(len(records))...)
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+func appendSection(buf []byte, tag string, payload []byte) []byte {
+	header := make([]byte, SectionHeaderLength)
+	copy(header, tag)
+	writeIntLong(header, 4, int64(len(payload)))
+	return append(append(buf, header...), payload...)
+}
+
+// testMMDBRecord is one network of a test MMDB file and its data record,
+// built of maps, arrays, strings, ints, float64s and bools.
+type testMMDBRecord struct {
+	network string
+	value   interface{}
+}
+
+// testMMDB encodes records as a MaxMind DB. IPv4 networks of an ipv6 file
+// live in ::/96, aliased at ::ffff:0:0/96. Repeated strings are written as
+// pointers.
+func testMMDB(ipVersion, recordSize int, databaseType string, records []testMMDBRecord) []byte {
+	type node struct {
+		child [2]*node
+		data  [2]int
+	}
+	root := &node{}
+	insert := func(key []byte, bits, data int) {
+		n := root
+		for i := 0; i < bits-1; i++ {
+			bit := key[i/8] >> (7 - i%8) & 1
+			if n.child[bit] == nil {
+				n.child[bit] = &node{}
+			}
+			n = n.child[bit]
+		}
+		bit := key[(bits-1)/8] >> (7 - (bits-1)%8) & 1
+		n.data[bit] = data
+	}
+
+	data := &testMMDBEncoder{strs: make(map[string]int)}
+	for _, r := range records {
+		p := netip.MustParsePrefix(r.network)
+		key, bits := p.Addr().AsSlice(), p.Bits()
+		if ipVersion == 6 && p.Addr().Is4() {
+			key, bits = append(make([]byte, 12), key...), bits+96
+		}
+		insert(key, bits, len(data.b)+1)
+		data.encode(r.value)
+	}
+	if ipVersion == 6 {
+		n := root
+		for i := 0; i < 95 && n != nil; i++ {
+			n = n.child[0]
+		}
+		alias := root
+		key := netip.MustParseAddr("::ffff:0:0").AsSlice()
+		for i := 0; i < 95; i++ {
+			bit := key[i/8] >> (7 - i%8) & 1
+			if alias.child[bit] == nil {
+				alias.child[bit] = &node{}
+			}
+			alias = alias.child[bit]
+		}
+		if n != nil {
+			alias.child[1] = n.child[0]
+		}
+	}
+
+	nodes := []*node{root}
+	index := map[*node]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].child {
+			if _, ok := index[c]; c != nil && !ok {
+				index[c] = len(nodes)
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	var b []byte
+	for _, n := range nodes {
+		var rec [2]uint32
+		for bit := range rec {
+			switch {
+			case n.child[bit] != nil:
+				rec[bit] = uint32(index[n.child[bit]])
+			case n.data[bit] != 0:
+				rec[bit] = uint32(len(nodes) + 16 + n.data[bit] - 1)
+			default:
+				rec[bit] = uint32(len(nodes))
+			}
+		}
+		l, r := rec[0], rec[1]
+		switch recordSize {
+		case 24:
+			b = append(b, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			b = append(b, byte(l>>16), byte(l>>8), byte(l), byte(l>>24)<<4|byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		default:
+			b = append(b, byte(l>>24), byte(l>>16), byte(l>>8), byte(l), byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	b = append(b, make([]byte, 16)...)
+	b = append(b, data.b...)
+	b = append(b, mmdb.MetadataStart...)
+
+	meta := &testMMDBEncoder{}
+	meta.encode(map[string]interface{}{
+		"binary_format_major_version": 2,
+		"binary_format_minor_version": 0,
+		"build_epoch":                 1714550400,
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{"en": "test"},
+		"ip_version":                  ipVersion,
+		"languages":                   []interface{}{"en", "zh-CN"},
+		"node_count":                  len(nodes),
+		"record_size":                 recordSize,
+	})
+	return append(b, meta.b...)
+}
+
+type testMMDBEncoder struct {
+	b    []byte
+	strs map[string]int
+}
+
+func (e *testMMDBEncoder) control(typ, size int) {
+	if typ > 7 {
+		e.b = append(e.b, byte(size), byte(typ-7))
+		return
+	}
+	e.b = append(e.b, byte(typ<<5|size))
+}
+
+func (e *testMMDBEncoder) encode(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		if off, ok := e.strs[v]; ok {
+			e.b = append(e.b, byte(mmdb.Pointer<<5|off>>8), byte(off))
+			return
+		}
+		if e.strs != nil && len(v) > 2 {
+			e.strs[v] = len(e.b)
+		}
+		e.control(mmdb.String, len(v))
+		e.b = append(e.b, v...)
+	case int:
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(v))
+		e.control(mmdb.Uint32, 4)
+		e.b = append(e.b, buf[:]...)
+	case float64:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+		e.control(mmdb.Double, 8)
+		e.b = append(e.b, buf[:]...)
+	case bool:
+		n := 0
+		if v {
+			n = 1
+		}
+		e.control(mmdb.Bool, n)
+	case []interface{}:
+		e.control(mmdb.Array, len(v))
+		for _, x := range v {
+			e.encode(x)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.control(mmdb.Map, len(v))
+		for _, k := range keys {
+			e.encode(k)
+			e.encode(v[k])
+		}
+	default:
+		panic(fmt.Sprintf("unsupported mmdb test value %T", v))
+	}
+}
+
+// testXdb encodes records, whose info is the region, as an xdb file with
+// the ranges split at /16 boundaries.
+func testXdb(records []testRecord) []byte {
+	buf := make([]byte, XdbHeaderLength+XdbVectorIndexSize)
+	ptrs := make(map[string]int)
+	var index []byte
+	for _, r := range records {
+		ptr, ok := ptrs[r.info]
+		if !ok {
+			ptr = len(buf)
+			ptrs[r.info] = ptr
+			buf = append(buf, r.info...)
+		}
+		for start := r.startIP; start <= r.endIP; start = start | 0xFFFF + 1 {
+			end := start | 0xFFFF
+			if end > r.endIP {
+				end = r.endIP
+			}
+			b := make([]byte, XdbSegmentIndexLength)
+			binary.LittleEndian.PutUint32(b, uint32(start))
+			binary.LittleEndian.PutUint32(b[4:], uint32(end))
+			binary.LittleEndian.PutUint16(b[8:], uint16(len(r.info)))
+			binary.LittleEndian.PutUint32(b[10:], uint32(ptr))
+			index = append(index, b...)
+		}
+	}
+
+	indexPtr := len(buf)
+	for off := 0; off < len(index); off += XdbSegmentIndexLength {
+		ptr := int64(indexPtr + off)
+		p := XdbHeaderLength + int(GetLong(index, int64(off))>>16)*XdbVectorIndexLength
+		if GetLong(buf, int64(p)) == 0 {
+			writeIntLong(buf, p, ptr)
+		}
+		writeIntLong(buf, p+4, ptr+XdbSegmentIndexLength)
+	}
+	buf = append(buf, index...)
+
+	binary.LittleEndian.PutUint16(buf, XdbVersion)
+	binary.LittleEndian.PutUint16(buf[2:], XdbVectorIndexPolicy)
+	writeIntLong(buf, 4, 1714550400)
+	writeIntLong(buf, 8, int64(indexPtr))
+	writeIntLong(buf, 12, int64(len(buf)-XdbSegmentIndexLength))
+	return buf
+}
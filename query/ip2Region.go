@@ -1,17 +1,24 @@
 package ip2region
 
 import (
-	"errors"
-	"io/ioutil"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const (
 	IndexBlockLength  = 12
 	TotalHeaderLength = 8192
+
+	// SectionHeaderLength is the size of the tag and length that precede
+	// every optional section after the index blocks.
+	SectionHeaderLength = 8
 )
 
 type IpInfo struct {
@@ -31,9 +38,13 @@ func (ip IpInfo) String() string {
 }
 
 type Ip2Region struct {
-	// db file handler
+	// db file handler, nil unless opened from a path
 	dbFileHandler *os.File
 
+	// the db is read through reader, closer is closed by Close
+	reader io.ReaderAt
+	closer io.Closer
+
 	//header block info
 
 	headerSip []int64
@@ -45,15 +56,45 @@ type Ip2Region struct {
 	lastIndexPtr  int64
 	totalBlocks   int64
 
+	// optional sections after the index blocks
+	sections  map[string]section
+	vectorPtr int64
+	metadata  *Metadata
+
+	// data blocks in the upstream v1 layout, whose ids come from codes
+	upstream bool
+	codes    *CodeTables
+
+	// start of the free text trailer after the sections
+	trailerPtr int64
+
 	// for memory mode only
 	// the original db binary string
 
 	dbBinStr []byte
 	dbFile   string
+	dbSize   int64
+	mmapped  bool
+
+	loadOnce sync.Once
+	loadErr  error
+	inMemory int32
 
-	mu sync.Mutex
+	// read locked by the searches of dbBinStr and write locked by Close,
+	// which unmaps it and sets closed
+	memMu  sync.RWMutex
+	closed bool
+
+	// used by Search and the typed search methods
+	algorithm Algorithm
+
+	// decoded data blocks by data pointer
+	infoMu sync.RWMutex
+	infos  map[int64]IpInfo
 }
 
+// New opens the db file and reads its super block and header blocks, so
+// that every search method is safe for concurrent use once it returns.
 func New(path string) (*Ip2Region, error) {
 
 	file, err := os.Open(path)
@@ -61,127 +102,364 @@ func New(path string) (*Ip2Region, error) {
 		return nil, err
 	}
 
-	return &Ip2Region{
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	ipr := &Ip2Region{
 		dbFile:        path,
 		dbFileHandler: file,
-	}, nil
+		reader:        file,
+		closer:        file,
+		dbSize:        fi.Size(),
+	}
+	if err := ipr.init(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return ipr, nil
 }
 
-func (ipr *Ip2Region) Close() error {
-	return ipr.dbFileHandler.Close()
+// NewMmap opens the db file like New and maps it into memory, so that
+// MemorySearch runs against the mapped pages instead of a heap copy and
+// processes searching the same file share the page cache. The mapping is
+// released by Close once the searches reading it have finished.
+func NewMmap(path string) (*Ip2Region, error) {
+	ipr, err := New(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ipr.loadOnce.Do(func() {
+		ipr.dbBinStr, ipr.loadErr = mmapFile(ipr.dbFileHandler)
+		if ipr.loadErr == nil {
+			atomic.StoreInt32(&ipr.inMemory, 1)
+		}
+	})
+	if ipr.loadErr != nil {
+		ipr.dbFileHandler.Close()
+		return nil, ipr.loadErr
+	}
+	ipr.mmapped = true
+	return ipr, nil
 }
 
-func (ipr *Ip2Region) LoadToMemory() error {
-	var err error
-	if ipr.totalBlocks == 0 {
-		ipr.dbBinStr, err = ioutil.ReadFile(ipr.dbFile)
+func (ipr *Ip2Region) init() error {
+	buffer := make([]byte, 8+TotalHeaderLength)
+	if err := ipr.readAt(buffer, 0, "header blocks"); err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
+	ipr.firstIndexPtr = GetLong(buffer, 0)
+	ipr.lastIndexPtr = GetLong(buffer, 4)
+	ipr.totalBlocks = (ipr.lastIndexPtr-ipr.firstIndexPtr)/IndexBlockLength + 1
+	if ipr.firstIndexPtr < int64(len(buffer)) || ipr.totalBlocks < 0 ||
+		(ipr.lastIndexPtr-ipr.firstIndexPtr)%IndexBlockLength != 0 ||
+		ipr.lastIndexPtr+IndexBlockLength > ipr.dbSize {
+		return corruptf("super block index pointers %d-%d out of bounds", ipr.firstIndexPtr, ipr.lastIndexPtr)
+	}
+
+	for i := 8; i < len(buffer); i += 8 {
+		startIp := GetLong(buffer, int64(i))
+		dataPar := GetLong(buffer, int64(i+4))
+		if dataPar == 0 {
+			break
+		}
+
+		if dataPar < ipr.firstIndexPtr || dataPar > ipr.lastIndexPtr+IndexBlockLength {
+			return corruptf("header block %d points outside the index", i/8-1)
+		}
+		if n := len(ipr.headerSip); n > 0 && (startIp < ipr.headerSip[n-1] || dataPar < ipr.headerPtr[n-1]) {
+			return corruptf("header block %d is out of order", i/8-1)
 		}
 
-		ipr.firstIndexPtr = GetLong(ipr.dbBinStr, 0)
-		ipr.lastIndexPtr = GetLong(ipr.dbBinStr, 4)
-		ipr.totalBlocks = (ipr.lastIndexPtr-ipr.firstIndexPtr)/IndexBlockLength + 1
+		ipr.headerSip = append(ipr.headerSip, startIp)
+		ipr.headerPtr = append(ipr.headerPtr, dataPar)
 	}
-	return err
-}
+	ipr.headerLen = int64(len(ipr.headerSip))
 
-// Notion: Need to call a before LoadToMemory
-func (ipr *Ip2Region) MemorySearch(ipStr string) (ipInfo IpInfo, err error) {
+	if err := ipr.readSections(ipr.dbSize); err != nil {
+		return err
+	}
+	if vs, ok := ipr.sections[SectionVectorIndex]; ok && vs.length == VectorIndexSize {
+		ipr.vectorPtr = vs.ptr
+	}
+	if s, ok := ipr.sections[SectionIPv6Index]; ok && s.length%IndexBlock6Length != 0 {
+		return corruptf("ipv6 index length %d is not a multiple of %d", s.length, IndexBlock6Length)
+	}
+	if err := ipr.readMetadata(); err != nil {
+		return err
+	}
+	return ipr.detectLayout()
+}
 
-	//ipr.mu.Lock()
-	//if ipr.totalBlocks == 0 {
-	//	ipr.LoadToMemory()
-	//}
-	//ipr.mu.Unlock()
+type section struct {
+	ptr    int64
+	length int64
+}
 
-	ipInfo = IpInfo{}
+// readSections collects the tagged sections that follow the index blocks.
+// Files without them continue with the free text trailer, which is not a
+// valid tag and ends the scan.
+func (ipr *Ip2Region) readSections(size int64) error {
+	ipr.sections = make(map[string]section)
+	buffer := make([]byte, SectionHeaderLength)
+	off := ipr.lastIndexPtr + IndexBlockLength
+	for off+SectionHeaderLength <= size {
+		if err := ipr.readAt(buffer, off, "section header"); err != nil {
+			return err
+		}
+		if !isSectionTag(buffer[:4]) {
+			break
+		}
+		length := GetLong(buffer, 4)
+		if off+SectionHeaderLength+length > size {
+			break
+		}
+		ipr.sections[string(buffer[:4])] = section{ptr: off + SectionHeaderLength, length: length}
+		off += SectionHeaderLength + length
+	}
+	ipr.trailerPtr = off
+	return nil
+}
 
-	if ipr.totalBlocks == 0 {
-		ipr.dbBinStr, err = ioutil.ReadFile(ipr.dbFile)
+func isSectionTag(b []byte) bool {
+	for _, c := range b {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
 
+// Close closes the db file and unmaps the db of NewMmap once the searches
+// reading it have finished. Searches started afterwards fail with
+// os.ErrClosed.
+func (ipr *Ip2Region) Close() error {
+	ipr.memMu.Lock()
+	if ipr.closed {
+		ipr.memMu.Unlock()
+		return nil
+	}
+	ipr.closed = true
+	atomic.StoreInt32(&ipr.inMemory, 0)
+	if ipr.mmapped {
+		err := munmapFile(ipr.dbBinStr)
+		ipr.dbBinStr = nil
 		if err != nil {
+			ipr.memMu.Unlock()
+			ipr.dbFileHandler.Close()
+			return err
+		}
+	}
+	ipr.memMu.Unlock()
 
-			return ipInfo, err
+	if ipr.closer == nil {
+		return nil
+	}
+	return ipr.closer.Close()
+}
+
+// LoadToMemory reads the whole db file into memory for MemorySearch. Only
+// the first call does any work; later calls return its result.
+func (ipr *Ip2Region) LoadToMemory() error {
+	ipr.loadOnce.Do(func() {
+		// read through the open handle rather than the path, so that the
+		// pointers read by New stay valid if the file has been replaced
+		ipr.dbBinStr = make([]byte, ipr.dbSize)
+		ipr.loadErr = ipr.readAt(ipr.dbBinStr, 0, "db")
+		if ipr.loadErr == nil {
+			atomic.StoreInt32(&ipr.inMemory, 1)
 		}
+	})
+	return ipr.loadErr
+}
 
-		ipr.firstIndexPtr = GetLong(ipr.dbBinStr, 0)
-		ipr.lastIndexPtr = GetLong(ipr.dbBinStr, 4)
-		ipr.totalBlocks = (ipr.lastIndexPtr-ipr.firstIndexPtr)/IndexBlockLength + 1
+// rlockMemory read locks dbBinStr for a search, or returns os.ErrClosed
+// once Close has been called.
+func (ipr *Ip2Region) rlockMemory() error {
+	ipr.memMu.RLock()
+	if ipr.closed {
+		ipr.memMu.RUnlock()
+		return os.ErrClosed
 	}
+	return nil
+}
 
-	ip, err := Ip2long(ipStr)
+// MemorySearch loads the db on first use if LoadToMemory was not called.
+func (ipr *Ip2Region) MemorySearch(ipStr string) (ipInfo IpInfo, err error) {
+	if err = ipr.LoadToMemory(); err != nil {
+		return IpInfo{}, err
+	}
+
+	ip, ip6, err := parseIP(ipStr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	var blk indexBlock
+	if ip6 != nil {
+		blk, err = ipr.memoryLookup6(ip6)
+	} else {
+		blk, err = ipr.memoryLookup(ip)
+	}
 	if err != nil {
-		return ipInfo, err
+		return IpInfo{}, err
 	}
+	return ipr.memoryIpInfo(blk.dataPtr)
+}
 
-	h := ipr.totalBlocks
-	var dataPtr, l int64
-	for l <= h {
+func (ipr *Ip2Region) BinarySearch(ipStr string) (ipInfo IpInfo, err error) {
+	ip, ip6, err := parseIP(ipStr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	var blk indexBlock
+	if ip6 != nil {
+		blk, err = ipr.fileLookup6(ip6)
+	} else {
+		blk, err = ipr.binaryLookup(ip)
+	}
+	if err != nil {
+		return IpInfo{}, err
+	}
+	return ipr.readIpInfo(blk.dataPtr)
+}
 
-		m := (l + h) >> 1
-		p := ipr.firstIndexPtr + m*IndexBlockLength
-		sip := GetLong(ipr.dbBinStr, p)
-		if ip < sip {
-			h = m - 1
-		} else {
-			eip := GetLong(ipr.dbBinStr, p+4)
-			if ip > eip {
-				l = m + 1
-			} else {
-				dataPtr = GetLong(ipr.dbBinStr, p+8)
-				break
-			}
-		}
+func (ipr *Ip2Region) BtreeSearch(ipStr string) (ipInfo IpInfo, err error) {
+	ip, ip6, err := parseIP(ipStr)
+	if err != nil {
+		return IpInfo{}, err
 	}
-	if dataPtr == 0 {
-		return ipInfo, errors.New("not found")
+	var blk indexBlock
+	if ip6 != nil {
+		blk, err = ipr.fileLookup6(ip6)
+	} else {
+		blk, err = ipr.btreeLookup(ip)
 	}
+	if err != nil {
+		return IpInfo{}, err
+	}
+	return ipr.readIpInfo(blk.dataPtr)
+}
 
-	dataLen := (dataPtr >> 24) & 0xFF
-	dataPtr = dataPtr & 0x00FFFFFF
-	ipInfo = getIpInfo(ipr.dbBinStr[(dataPtr) : dataPtr+dataLen])
-	return ipInfo, nil
+// SearchUint32 searches an IPv4 address given as a number, e.g. 0x7f000001
+// for 127.0.0.1. Like SearchAddr and SearchIP it uses the same algorithm as
+// Search, and allocates nothing once the region has been decoded, in file
+// modes as long as the db is read from an *os.File or a bytes.Reader.
+func (ipr *Ip2Region) SearchUint32(ip uint32) (IpInfo, error) {
+	return ipr.search(int64(ip))
 }
 
-func (ipr *Ip2Region) BinarySearch(ipStr string) (ipInfo IpInfo, err error) {
-	ipInfo = IpInfo{}
-	if ipr.totalBlocks == 0 {
-		ipr.dbFileHandler.Seek(0, 0)
-		superBlock := make([]byte, 8)
-		ipr.dbFileHandler.Read(superBlock)
-		ipr.firstIndexPtr = GetLong(superBlock, 0)
-		ipr.lastIndexPtr = GetLong(superBlock, 4)
-		ipr.totalBlocks = (ipr.lastIndexPtr-ipr.firstIndexPtr)/IndexBlockLength + 1
+// SearchAddr searches an IPv4 or IPv6 address, IPv4-mapped IPv6 addresses
+// are searched as IPv4.
+func (ipr *Ip2Region) SearchAddr(addr netip.Addr) (IpInfo, error) {
+	if addr.Is4() || addr.Is4In6() {
+		b := addr.As4()
+		return ipr.SearchUint32(binary.BigEndian.Uint32(b[:]))
+	}
+	if !addr.IsValid() {
+		return IpInfo{}, &ParseError{Input: addr.String(), Msg: "invalid address"}
+	}
+	b := addr.As16()
+	return ipr.search6(b[:])
+}
+
+// SearchIP searches a 4 or 16 byte net.IP.
+func (ipr *Ip2Region) SearchIP(ip net.IP) (IpInfo, error) {
+	if v4 := ip.To4(); v4 != nil {
+		return ipr.SearchUint32(binary.BigEndian.Uint32(v4))
 	}
+	if len(ip) != net.IPv6len {
+		return IpInfo{}, &ParseError{Input: ip.String(), Msg: "invalid address"}
+	}
+	return ipr.search6(ip)
+}
 
-	var l, dataPtr, p int64
+// indexBlock is the index block matched by a search.
+type indexBlock struct {
+	startIP netip.Addr
+	endIP   netip.Addr
+	dataPtr int64
+}
 
-	h := ipr.totalBlocks
+// indexBlockAt decodes the IPv4 index block at offset p of index.
+func indexBlockAt(index []byte, p int64) indexBlock {
+	return indexBlock{
+		startIP: long2Addr(GetLong(index, p)),
+		endIP:   long2Addr(GetLong(index, p+4)),
+		dataPtr: GetLong(index, p+8),
+	}
+}
 
-	ip, err := Ip2long(ipStr)
+func long2Addr(ip int64) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+}
 
-	if err != nil {
-		return
+func (ipr *Ip2Region) memoryLookup(ip int64) (indexBlock, error) {
+	if err := ipr.rlockMemory(); err != nil {
+		return indexBlock{}, err
+	}
+	defer ipr.memMu.RUnlock()
+
+	var index []byte
+	if ipr.vectorPtr != 0 {
+		p := ipr.vectorPtr + (ip>>16)*VectorIndexLength
+		sptr, eptr := GetLong(ipr.dbBinStr, p), GetLong(ipr.dbBinStr, p+4)
+		if sptr == 0 {
+			return indexBlock{}, ErrNotFound
+		}
+		if err := ipr.checkVector(sptr, eptr); err != nil {
+			return indexBlock{}, err
+		}
+		index = ipr.dbBinStr[sptr : eptr+IndexBlockLength]
+	} else {
+		index = ipr.dbBinStr[ipr.firstIndexPtr : ipr.lastIndexPtr+IndexBlockLength]
+	}
+	p := searchIndex(index, ip)
+	if p < 0 {
+		return indexBlock{}, ErrNotFound
 	}
 
-	for l <= h {
-		m := (l + h) >> 1
+	return indexBlockAt(index, p), nil
+}
 
-		p = m * IndexBlockLength
+func (ipr *Ip2Region) binaryLookup(ip int64) (blk indexBlock, err error) {
+	var l, p int64
+
+	h := ipr.totalBlocks - 1
+	base := ipr.firstIndexPtr
 
-		_, err = ipr.dbFileHandler.Seek(ipr.firstIndexPtr+p, 0)
+	bp := getBuffer(IndexBlockLength)
+	defer putBuffer(bp)
+	buffer := *bp
+	if ipr.vectorPtr != 0 {
+		err = ipr.readAt(buffer[:VectorIndexLength], ipr.vectorPtr+(ip>>16)*VectorIndexLength, "vector index")
 		if err != nil {
 			return
 		}
+		base = GetLong(buffer, 0)
+		if base == 0 {
+			err = ErrNotFound
+			return
+		}
+		if err = ipr.checkVector(base, GetLong(buffer, 4)); err != nil {
+			return
+		}
+		h = (GetLong(buffer, 4) - base) / IndexBlockLength
+	}
 
-		buffer := make([]byte, IndexBlockLength)
-		_, err = ipr.dbFileHandler.Read(buffer)
+	for l <= h {
+		m := (l + h) >> 1
 
-		if err != nil {
+		p = m * IndexBlockLength
 
+		err = ipr.readAt(buffer, base+p, "index block")
+		if err != nil {
+			return
 		}
+
 		sip := GetLong(buffer, 0)
 		if ip < sip {
 			h = m - 1
@@ -190,111 +468,64 @@ func (ipr *Ip2Region) BinarySearch(ipStr string) (ipInfo IpInfo, err error) {
 			if ip > eip {
 				l = m + 1
 			} else {
-				dataPtr = GetLong(buffer, 8)
-				break
+				return indexBlockAt(buffer, 0), nil
 			}
 		}
 	}
 
-	if dataPtr == 0 {
-		err = errors.New("not found")
-		return
-	}
-
-	dataLen := (dataPtr >> 24) & 0xFF
-	dataPtr = dataPtr & 0x00FFFFFF
-
-	ipr.dbFileHandler.Seek(dataPtr, 0)
-	data := make([]byte, dataLen)
-	ipr.dbFileHandler.Read(data)
-	ipInfo = getIpInfo(data[4:dataLen])
-	err = nil
+	err = ErrNotFound
 	return
 }
 
-func (ipr *Ip2Region) BtreeSearch(ipStr string) (ipInfo IpInfo, err error) {
-	ipInfo = IpInfo{}
-	ip, err := Ip2long(ipStr)
-
-	if ipr.headerLen == 0 {
-		ipr.dbFileHandler.Seek(8, 0)
-
-		buffer := make([]byte, TotalHeaderLength)
-		ipr.dbFileHandler.Read(buffer)
-		var idx int64
-		for i := 0; i < TotalHeaderLength; i += 8 {
-			startIp := GetLong(buffer, int64(i))
-			dataPar := GetLong(buffer, int64(i+4))
-			if dataPar == 0 {
-				break
-			}
-
-			ipr.headerSip = append(ipr.headerSip, startIp)
-			ipr.headerPtr = append(ipr.headerPtr, dataPar)
-			idx++
-		}
-
-		ipr.headerLen = idx
+func (ipr *Ip2Region) btreeLookup(ip int64) (blk indexBlock, err error) {
+	if ipr.headerLen < 2 || ip < ipr.headerSip[0] {
+		err = ErrNotFound
+		return
 	}
 
-	var l, sptr, eptr int64
-	h := ipr.headerLen
-
-	for l <= h {
-		m := l + h>>1
-		if m < ipr.headerLen {
-			if ip == ipr.headerSip[m] {
-				if m > 0 {
-					sptr = ipr.headerPtr[m-1]
-					eptr = ipr.headerPtr[m]
-				} else {
-					sptr = ipr.headerPtr[m]
-					eptr = ipr.headerPtr[m+1]
-				}
-				break
-			}
-			if ip < ipr.headerSip[m] {
-				if m == 0 {
-					sptr = ipr.headerPtr[m]
-					eptr = ipr.headerPtr[m+1]
-					break
-				} else if ip > ipr.headerSip[m-1] {
-					sptr = ipr.headerPtr[m-1]
-					eptr = ipr.headerPtr[m]
-					break
-				}
-				h = m - 1
-			} else {
-				if m == ipr.headerLen-1 {
-					sptr = ipr.headerPtr[m-1]
-					eptr = ipr.headerPtr[m]
-					break
-				} else if ip <= ipr.headerSip[m+1] {
-					sptr = ipr.headerPtr[m]
-					eptr = ipr.headerPtr[m+1]
-					break
-				}
-				l = m + 1
-			}
+	// find the last header block starting at or before ip, the index
+	// entries for ip are between its pointer and the next one (inclusive)
+	var l, h int64 = 0, ipr.headerLen - 1
+	for l < h {
+		m := (l + h + 1) >> 1
+		if ipr.headerSip[m] <= ip {
+			l = m
+		} else {
+			h = m - 1
 		}
+	}
+	if l == ipr.headerLen-1 {
+		l--
+	}
+	sptr, eptr := ipr.headerPtr[l], ipr.headerPtr[l+1]+IndexBlockLength
+	if end := ipr.lastIndexPtr + IndexBlockLength; eptr > end {
+		eptr = end
+	}
 
+	bp := getBuffer(eptr - sptr)
+	defer putBuffer(bp)
+	index := *bp
+	if err = ipr.readAt(index, sptr, "index blocks"); err != nil {
+		return
 	}
 
-	if sptr == 0 {
-		err = errors.New("not found")
+	p := searchIndex(index, ip)
+	if p < 0 {
+		err = ErrNotFound
 		return
 	}
 
-	blockLen := eptr - sptr
-	ipr.dbFileHandler.Seek(sptr, 0)
-	index := make([]byte, blockLen+IndexBlockLength)
-	ipr.dbFileHandler.Read(index)
-	var dataptr int64
-	h = blockLen / IndexBlockLength
-	l = 0
+	return indexBlockAt(index, p), nil
+}
+
+// searchIndex binary searches ip in a run of consecutive index blocks and
+// returns the offset of the block containing it, or -1.
+func searchIndex(index []byte, ip int64) int64 {
+	var l int64
+	h := int64(len(index))/IndexBlockLength - 1
 
 	for l <= h {
-		m := int64(l+h) >> 1
+		m := (l + h) >> 1
 		p := m * IndexBlockLength
 		sip := GetLong(index, p)
 		if ip < sip {
@@ -304,25 +535,123 @@ func (ipr *Ip2Region) BtreeSearch(ipStr string) (ipInfo IpInfo, err error) {
 			if ip > eip {
 				l = m + 1
 			} else {
-				dataptr = GetLong(index, p+8)
-				break
+				return p
 			}
 		}
 	}
+	return -1
+}
 
-	if dataptr == 0 {
-		err = errors.New("not found")
-		return
+// buffers holds the read buffers of the file searches, which escape to
+// the io.ReaderAt and would otherwise be allocated by every search.
+var buffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, TotalHeaderLength)
+		return &b
+	},
+}
+
+// getBuffer returns a buffer of n bytes from buffers, to be returned with
+// putBuffer.
+func getBuffer(n int64) *[]byte {
+	bp := buffers.Get().(*[]byte)
+	if int64(cap(*bp)) < n {
+		*bp = make([]byte, n)
 	}
+	*bp = (*bp)[:n]
+	return bp
+}
 
-	dataLen := (dataptr >> 24) & 0xFF
-	dataPtr := dataptr & 0x00FFFFFF
+func putBuffer(bp *[]byte) {
+	buffers.Put(bp)
+}
 
-	ipr.dbFileHandler.Seek(dataPtr, 0)
-	data := make([]byte, dataLen)
-	ipr.dbFileHandler.Read(data)
-	ipInfo = getIpInfo(data[4:])
-	return
+// cachedIpInfo returns the already decoded data block of dataPtr. A db has
+// only as many data blocks as distinct regions, so the cache stays small.
+func (ipr *Ip2Region) cachedIpInfo(dataPtr int64) (IpInfo, bool) {
+	ipr.infoMu.RLock()
+	info, ok := ipr.infos[dataPtr]
+	ipr.infoMu.RUnlock()
+	return info, ok
+}
+
+func (ipr *Ip2Region) cacheIpInfo(dataPtr int64, info IpInfo) {
+	ipr.infoMu.Lock()
+	if ipr.infos == nil {
+		ipr.infos = make(map[int64]IpInfo)
+	}
+	ipr.infos[dataPtr] = info
+	ipr.infoMu.Unlock()
+}
+
+// checkVector validates the index block pointers of a vector index entry.
+func (ipr *Ip2Region) checkVector(sptr, eptr int64) error {
+	if sptr < ipr.firstIndexPtr || eptr > ipr.lastIndexPtr || eptr < sptr ||
+		(sptr-ipr.firstIndexPtr)%IndexBlockLength != 0 || (eptr-sptr)%IndexBlockLength != 0 {
+		return corruptf("vector index entry %d-%d points outside the index", sptr, eptr)
+	}
+	return nil
+}
+
+// checkData validates an index data pointer and splits it into the data
+// block offset and length.
+func (ipr *Ip2Region) checkData(dataPtr int64) (ptr, length int64, err error) {
+	length = (dataPtr >> 24) & 0xFF
+	ptr = dataPtr & 0x00FFFFFF
+	if ptr+length > ipr.dbSize {
+		return 0, 0, corruptf("data block %d+%d is past the end of the db", ptr, length)
+	}
+	return ptr, length, nil
+}
+
+// memoryIpInfo decodes the data block referenced by an index data pointer
+// from the in-memory db.
+func (ipr *Ip2Region) memoryIpInfo(dataPtr int64) (IpInfo, error) {
+	if info, ok := ipr.cachedIpInfo(dataPtr); ok {
+		return info, nil
+	}
+
+	ptr, dataLen, err := ipr.checkData(dataPtr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	if err := ipr.rlockMemory(); err != nil {
+		return IpInfo{}, err
+	}
+	info := ipr.decodeIpInfo(ipr.dbBinStr[ptr : ptr+dataLen])
+	ipr.memMu.RUnlock()
+	ipr.cacheIpInfo(dataPtr, info)
+	return info, nil
+}
+
+// ipInfo decodes the data block referenced by an index data pointer from
+// memory once the db has been loaded and from the file otherwise.
+func (ipr *Ip2Region) ipInfo(dataPtr int64) (IpInfo, error) {
+	if atomic.LoadInt32(&ipr.inMemory) == 1 {
+		return ipr.memoryIpInfo(dataPtr)
+	}
+	return ipr.readIpInfo(dataPtr)
+}
+
+// readIpInfo reads the data block referenced by an index data pointer.
+func (ipr *Ip2Region) readIpInfo(dataPtr int64) (IpInfo, error) {
+	if info, ok := ipr.cachedIpInfo(dataPtr); ok {
+		return info, nil
+	}
+
+	ptr, dataLen, err := ipr.checkData(dataPtr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+
+	bp := getBuffer(dataLen)
+	defer putBuffer(bp)
+	if err := ipr.readAt(*bp, ptr, "data block"); err != nil {
+		return IpInfo{}, err
+	}
+	info := ipr.decodeIpInfo(*bp)
+	ipr.cacheIpInfo(dataPtr, info)
+	return info, nil
 }
 
 func GetLong(b []byte, offset int64) int64 {
@@ -345,19 +674,15 @@ func writeIntLong(b []byte, offset int, v int64) {
 	b[offset] = byte((v >> 24) & 0xFF)
 }
 
+// Ip2long parses a dotted-quad IPv4 address, anything else is rejected
+// with a *ParseError. Use NormalizeAddr for addresses with ports, brackets
+// or whitespace.
 func Ip2long(IpStr string) (int64, error) {
-	bits := strings.Split(IpStr, ".")
-	if len(bits) != 4 {
-		return 0, errors.New("ip format error")
-	}
-
-	var sum int64
-	for i, n := range bits {
-		bit, _ := strconv.ParseInt(n, 10, 64)
-		sum += bit << uint(24-8*i)
+	ip, err := parseIPv4(IpStr)
+	if err != nil {
+		return 0, err
 	}
-
-	return sum, nil
+	return ip, nil
 }
 
 func IpLong2String(n int64) string {
@@ -375,12 +700,9 @@ func getIpInfo(line []byte) IpInfo {
 
 	lineSlice := strings.Split(string(line), "|")
 	ipInfo := IpInfo{}
-	length := len(lineSlice)
 	//ipInfo.RegionId = regionId
-	if length < 6 {
-		for i := 0; i <= 6-length; i++ {
-			lineSlice = append(lineSlice, "")
-		}
+	for len(lineSlice) < 7 {
+		lineSlice = append(lineSlice, "")
 	}
 	rId, err := strconv.Atoi(lineSlice[4])
 	if err != nil {
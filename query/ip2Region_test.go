@@ -1,29 +1,56 @@
 package ip2region
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
 
 const IpDbAddress = "../data/ip2region.db"
 
+// dbPath is IpDbAddress, or a generated db when the data file is absent.
+var dbPath = IpDbAddress
+
 var ipr *Ip2Region
 
 func TestMain(m *testing.M) {
 	log.Print("ini test")
+	var dir string
+	if _, err := os.Stat(IpDbAddress); os.IsNotExist(err) {
+		dir, err = ioutil.TempDir("", "ip2region")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dbPath = filepath.Join(dir, "ip2region.db")
+		if err := writeTestDB(dbPath, testRecords(4096), testDBOptions{}); err != nil {
+			log.Fatal(err)
+		}
+	}
 	var err error
-	ipr, err = New(IpDbAddress)
+	ipr, err = New(dbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	os.Exit(m.Run())
+	code := m.Run()
+	ipr.Close()
+	if dir != "" {
+		os.RemoveAll(dir)
+	}
+	os.Exit(code)
 }
 
 func BenchmarkBtreeSearch(B *testing.B) {
-	region, err := New(IpDbAddress)
+	region, err := New(dbPath)
 	if err != nil {
 		B.Error(err)
 	}
@@ -34,7 +61,7 @@ func BenchmarkBtreeSearch(B *testing.B) {
 }
 
 func BenchmarkMemorySearch(B *testing.B) {
-	region, err := New(IpDbAddress)
+	region, err := New(dbPath)
 	if err != nil {
 		B.Error(err)
 	}
@@ -47,7 +74,7 @@ func BenchmarkMemorySearch(B *testing.B) {
 }
 
 func BenchmarkMemorySearchParallel(B *testing.B) {
-	region, err := New(IpDbAddress)
+	region, err := New(dbPath)
 	_ = region
 	if err != nil {
 		B.Error(err)
@@ -63,7 +90,7 @@ func BenchmarkMemorySearchParallel(B *testing.B) {
 }
 
 func BenchmarkBinarySearch(B *testing.B) {
-	region, err := New(IpDbAddress)
+	region, err := New(dbPath)
 	if err != nil {
 		B.Error(err)
 	}
@@ -75,7 +102,7 @@ func BenchmarkBinarySearch(B *testing.B) {
 
 func TestIp2Region_MemorySearch(t *testing.T) {
 	var err error
-	ipr, err = New(IpDbAddress)
+	ipr, err = New(dbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -98,3 +125,384 @@ func TestIp2Region_MemorySearch(t *testing.T) {
 		}
 	}
 }
+
+func TestIp2Region_SearchModesAgree(t *testing.T) {
+	region, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer region.Close()
+
+	ipNum, _ := Ip2long("255.255.255.255")
+	for i := 0; i < 2000; i++ {
+		ip := IpLong2String(rand.Int63n(ipNum + 1))
+		mem, err := region.MemorySearch(ip)
+		if err != nil {
+			t.Fatalf("MemorySearch(%s): %v", ip, err)
+		}
+		bin, err := region.BinarySearch(ip)
+		if err != nil {
+			t.Fatalf("BinarySearch(%s): %v", ip, err)
+		}
+		btree, err := region.BtreeSearch(ip)
+		if err != nil {
+			t.Fatalf("BtreeSearch(%s): %v", ip, err)
+		}
+		if mem != bin || mem != btree {
+			t.Fatalf("ip %s: memory %v, binary %v, btree %v", ip, mem, bin, btree)
+		}
+	}
+}
+
+// TestIp2Region_Concurrent is meant to be run with -race: every search
+// mode is hammered from many goroutines on one shared Ip2Region, starting
+// before the db has been loaded into memory.
+func TestIp2Region_Concurrent(t *testing.T) {
+	region, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer region.Close()
+
+	searches := map[string]func(string) (IpInfo, error){
+		"memory": region.MemorySearch,
+		"binary": region.BinarySearch,
+		"btree":  region.BtreeSearch,
+	}
+	ips := make([]string, 200)
+	for i := range ips {
+		ips[i] = IpLong2String(rand.Int63n(1 << 32))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for g := 0; g < 16; g++ {
+		for name, search := range searches {
+			wg.Add(1)
+			go func(name string, search func(string) (IpInfo, error)) {
+				defer wg.Done()
+				for _, ip := range ips {
+					if _, err := search(ip); err != nil {
+						errs <- fmt.Errorf("%s(%s): %v", name, ip, err)
+						return
+					}
+				}
+			}(name, search)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestNewMmap(t *testing.T) {
+	region, err := NewMmap(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for i := 0; i < 500; i++ {
+		ip := IpLong2String(rand.Int63n(1 << 32))
+		got, err := region.MemorySearch(ip)
+		if err != nil {
+			t.Fatalf("MemorySearch(%s): %v", ip, err)
+		}
+		want, err := ipr.BtreeSearch(ip)
+		if err != nil {
+			t.Fatalf("BtreeSearch(%s): %v", ip, err)
+		}
+		if got != want {
+			t.Fatalf("ip %s: mmap %v, btree %v", ip, got, want)
+		}
+	}
+
+	if err := region.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestNewMmap_close(t *testing.T) {
+	region, err := NewMmap(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// searches running while the db is unmapped either finish or fail
+	// with os.ErrClosed
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if _, err := region.MemorySearch(IpLong2String(rand.Int63n(1 << 32))); errors.Is(err, os.ErrClosed) {
+					return
+				} else if err != nil {
+					t.Errorf("%v", err)
+					return
+				}
+			}
+		}()
+	}
+	if err := region.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	wg.Wait()
+
+	if _, err := region.MemorySearch("1.2.3.4"); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("MemorySearch after Close = %v", err)
+	}
+	if _, err := region.SearchUint32(0x01020304); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("SearchUint32 after Close = %v", err)
+	}
+	if err := region.Iterate(func(Range) error { return nil }); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Iterate after Close = %v", err)
+	}
+	if err := region.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+}
+
+func BenchmarkMmapSearch(B *testing.B) {
+	region, err := NewMmap(dbPath)
+	if err != nil {
+		B.Fatal(err)
+	}
+	defer region.Close()
+	B.ResetTimer()
+	for i := 0; i < B.N; i++ {
+		region.MemorySearch("127.0.0.1")
+	}
+}
+
+func TestIp2Region_VectorIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// sparse ranges of various sizes, some spanning several /16s and some
+	// sharing one, with gaps in between
+	var records []testRecord
+	for ip := int64(1 << 24); ip < 1<<32-1<<26; ip += rand.Int63n(1<<22) + 1<<10 {
+		end := ip + rand.Int63n(1<<20)
+		records = append(records, testRecord{startIP: ip, endIP: end, info: "中国|0|0|0|0|0|" + strconv.Itoa(len(records))})
+		ip = end
+	}
+
+	plainPath, vectorPath := filepath.Join(dir, "plain.db"), filepath.Join(dir, "vector.db")
+	if err := writeTestDB(plainPath, records, testDBOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := writeTestDB(vectorPath, records, testDBOptions{vector: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	plain, err := New(plainPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer plain.Close()
+	vector, err := New(vectorPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer vector.Close()
+	if vector.vectorPtr == 0 {
+		t.Fatal("vector index section not found")
+	}
+
+	var probes []int64
+	for _, r := range records[:50] {
+		probes = append(probes, r.startIP-1, r.startIP, r.endIP, r.endIP+1)
+	}
+	for i := 0; i < 2000; i++ {
+		probes = append(probes, rand.Int63n(1<<32))
+	}
+	for _, n := range probes {
+		ip := IpLong2String(n)
+		want, wantErr := plain.BtreeSearch(ip)
+		for name, search := range map[string]func(string) (IpInfo, error){
+			"memory": vector.MemorySearch,
+			"binary": vector.BinarySearch,
+		} {
+			got, err := search(ip)
+			if got != want || (err == nil) != (wantErr == nil) {
+				t.Fatalf("%s(%s) = %v, %v; want %v, %v", name, ip, got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+func TestIp2Region_IPv6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dual.db")
+	err = writeTestDB(path, testRecords(16), testDBOptions{
+		vector: true,
+		ipv6: []testRecord6{
+			{"2001:db8::", "2001:db8::ffff", "中国|北京|北京市|联通|1|11|2"},
+			{"2001:db8:1::", "2001:db8:1:ffff:ffff:ffff:ffff:ffff", "日本|0|0|0|0|0|0"},
+			{"2400::", "24ff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "中国|广东|深圳市|电信|3|44|3"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, open := range []func(string) (*Ip2Region, error){New, NewMmap} {
+		region, err := open(path)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		for _, c := range []struct {
+			ip      string
+			country string
+		}{
+			{"2001:db8::1", "中国"},
+			{"2001:db8::ffff", "中国"},
+			{"2001:db8:1:2::3", "日本"},
+			{"2408:8000::1", "中国"},
+			{"2001:db8::1:0", ""},
+			{"::1", ""},
+			{"ff02::1", ""},
+			// IPv4-mapped addresses are looked up in the IPv4 index
+			{"::ffff:1.2.3.4", "中国"},
+		} {
+			for name, search := range map[string]func(string) (IpInfo, error){
+				"memory": region.MemorySearch,
+				"binary": region.BinarySearch,
+				"btree":  region.BtreeSearch,
+			} {
+				info, err := search(c.ip)
+				if c.country == "" {
+					if err == nil {
+						t.Fatalf("%s(%s) = %v, want not found", name, c.ip, info)
+					}
+					continue
+				}
+				if err != nil || info.Country != c.country {
+					t.Fatalf("%s(%s) = %v, %v; want country %s", name, c.ip, info, err, c.country)
+				}
+			}
+		}
+		region.Close()
+	}
+
+	if _, err := ipr.MemorySearch("2001:db8::zz"); err == nil {
+		t.Fatal("expected ip format error")
+	}
+}
+
+func TestIp2Region_SearchTyped(t *testing.T) {
+	for _, memory := range []bool{false, true} {
+		region, err := New(dbPath)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if memory {
+			if err := region.LoadToMemory(); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+
+		for i := 0; i < 500; i++ {
+			n := uint32(rand.Int63n(1 << 32))
+			ipStr := IpLong2String(int64(n))
+			want, err := ipr.BtreeSearch(ipStr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			got, err := region.SearchUint32(n)
+			if err != nil || got != want {
+				t.Fatalf("SearchUint32(%s) = %v, %v; want %v", ipStr, got, err, want)
+			}
+			got, err = region.SearchAddr(netip.MustParseAddr(ipStr))
+			if err != nil || got != want {
+				t.Fatalf("SearchAddr(%s) = %v, %v; want %v", ipStr, got, err, want)
+			}
+			got, err = region.SearchIP(net.ParseIP(ipStr))
+			if err != nil || got != want {
+				t.Fatalf("SearchIP(%s) = %v, %v; want %v", ipStr, got, err, want)
+			}
+		}
+
+		if _, err := region.SearchAddr(netip.Addr{}); err == nil {
+			t.Fatal("SearchAddr of the zero Addr should fail")
+		}
+		if _, err := region.SearchIP(net.IP{1, 2, 3}); err == nil {
+			t.Fatal("SearchIP of a 3 byte ip should fail")
+		}
+		region.Close()
+	}
+}
+
+func TestIp2Region_SearchTypedAllocs(t *testing.T) {
+	region, err := NewMmap(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer region.Close()
+
+	addr := netip.MustParseAddr("1.2.3.4")
+	ip := net.ParseIP("1.2.3.4")
+	// decode the region once
+	if _, err := region.SearchUint32(0x01020304); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		region.SearchUint32(0x01020304)
+		region.SearchAddr(addr)
+		region.SearchIP(ip)
+	})
+	if allocs != 0 {
+		t.Fatalf("got %v allocs per run, want 0", allocs)
+	}
+
+	// the file modes read into pooled buffers, which the race detector
+	// drops at random
+	if raceEnabled {
+		return
+	}
+	for _, a := range []Algorithm{BtreeAlgorithm, BinaryAlgorithm} {
+		s, err := NewSearcher(dbPath, WithAlgorithm(a))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		region := s.(*Ip2Region)
+		if _, err := region.SearchUint32(0x01020304); err != nil {
+			t.Fatalf("%v", err)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			region.SearchUint32(0x01020304)
+			region.SearchAddr(addr)
+			region.SearchIP(ip)
+		})
+		region.Close()
+		if allocs != 0 {
+			t.Fatalf("%s: got %v allocs per run, want 0", a, allocs)
+		}
+	}
+}
+
+func BenchmarkSearchUint32(B *testing.B) {
+	region, err := NewMmap(dbPath)
+	if err != nil {
+		B.Fatal(err)
+	}
+	defer region.Close()
+	B.ReportAllocs()
+	B.ResetTimer()
+	for i := 0; i < B.N; i++ {
+		region.SearchUint32(0x7f000001)
+	}
+}
@@ -0,0 +1,105 @@
+package ip2region
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+)
+
+// IPv6 ranges live in an optional section of index blocks sorted by start
+// ip, which point into the same data blocks as the IPv4 index:
+//
+//	+------------+-----------+---------------+
+//	| 16bytes    | 16bytes   | 4bytes        |
+//	+------------+-----------+---------------+
+//	 start ip     end ip      3 byte data ptr & 1 byte data length
+//
+// Addresses are stored in network byte order so that they compare
+// bytewise. IPv4 addresses, including IPv4-mapped IPv6 ones, are always
+// searched in the IPv4 index.
+const (
+	SectionIPv6Index = "IPV6"
+
+	IndexBlock6Length = 36
+)
+
+// searchIndex6 binary searches ip in a run of consecutive IPv6 index
+// blocks and returns the offset of the block containing it, or -1.
+func searchIndex6(index []byte, ip net.IP) int64 {
+	var l int64
+	h := int64(len(index))/IndexBlock6Length - 1
+
+	for l <= h {
+		m := (l + h) >> 1
+		p := m * IndexBlock6Length
+		if bytes.Compare(ip, index[p:p+16]) < 0 {
+			h = m - 1
+		} else if bytes.Compare(ip, index[p+16:p+32]) > 0 {
+			l = m + 1
+		} else {
+			return p
+		}
+	}
+	return -1
+}
+
+// indexBlock6At decodes the IPv6 index block at offset p of index.
+func indexBlock6At(index []byte, p int64) indexBlock {
+	var start, end [16]byte
+	copy(start[:], index[p:p+16])
+	copy(end[:], index[p+16:p+32])
+	return indexBlock{
+		startIP: netip.AddrFrom16(start),
+		endIP:   netip.AddrFrom16(end),
+		dataPtr: GetLong(index, p+32),
+	}
+}
+
+func (ipr *Ip2Region) memoryLookup6(ip net.IP) (indexBlock, error) {
+	s, ok := ipr.sections[SectionIPv6Index]
+	if !ok {
+		return indexBlock{}, ErrNotFound
+	}
+	if err := ipr.rlockMemory(); err != nil {
+		return indexBlock{}, err
+	}
+	defer ipr.memMu.RUnlock()
+
+	index := ipr.dbBinStr[s.ptr : s.ptr+s.length]
+	p := searchIndex6(index, ip)
+	if p < 0 {
+		return indexBlock{}, ErrNotFound
+	}
+
+	return indexBlock6At(index, p), nil
+}
+
+// fileLookup6 is the IPv6 search of both file modes, it reads one index
+// block from the file per probe.
+func (ipr *Ip2Region) fileLookup6(ip net.IP) (indexBlock, error) {
+	s, ok := ipr.sections[SectionIPv6Index]
+	if !ok {
+		return indexBlock{}, ErrNotFound
+	}
+
+	var l int64
+	h := s.length/IndexBlock6Length - 1
+	bp := getBuffer(IndexBlock6Length)
+	defer putBuffer(bp)
+	buffer := *bp
+	for l <= h {
+		m := (l + h) >> 1
+		if err := ipr.readAt(buffer, s.ptr+m*IndexBlock6Length, "ipv6 index block"); err != nil {
+			return indexBlock{}, err
+		}
+		if bytes.Compare(ip, buffer[:16]) < 0 {
+			h = m - 1
+		} else if bytes.Compare(ip, buffer[16:32]) > 0 {
+			l = m + 1
+		} else {
+			return indexBlock6At(buffer, 0), nil
+		}
+	}
+
+	return indexBlock{}, ErrNotFound
+}
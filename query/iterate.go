@@ -0,0 +1,68 @@
+package ip2region
+
+import "sync/atomic"
+
+// iterateChunk is the number of index blocks read from the file at a time.
+const iterateChunk = 4096
+
+// Iterate calls fn for every range of the db in address order, the IPv4
+// index blocks first and then the IPv6 ones. It stops at the first error
+// returned by fn or met reading the db and returns it.
+func (ipr *Ip2Region) Iterate(fn func(r Range) error) error {
+	err := ipr.iterateIndex(ipr.firstIndexPtr, ipr.totalBlocks, IndexBlockLength, indexBlockAt, fn)
+	if err != nil {
+		return err
+	}
+	if s, ok := ipr.sections[SectionIPv6Index]; ok {
+		return ipr.iterateIndex(s.ptr, s.length/IndexBlock6Length, IndexBlock6Length, indexBlock6At, fn)
+	}
+	return nil
+}
+
+// Ranges returns every range of the db in the order of Iterate.
+func (ipr *Ip2Region) Ranges() ([]Range, error) {
+	ranges := make([]Range, 0, ipr.totalBlocks)
+	err := ipr.Iterate(func(r Range) error {
+		ranges = append(ranges, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// iterateIndex walks total index blocks of blockLen bytes starting at ptr.
+func (ipr *Ip2Region) iterateIndex(ptr, total, blockLen int64, decode func([]byte, int64) indexBlock, fn func(Range) error) error {
+	buffer := make([]byte, iterateChunk*blockLen)
+	for i := int64(0); i < total; i += iterateChunk {
+		n := total - i
+		if n > iterateChunk {
+			n = iterateChunk
+		}
+		off := ptr + i*blockLen
+
+		// copied out of the in-memory db, Close may unmap it while fn runs
+		index := buffer[:n*blockLen]
+		if atomic.LoadInt32(&ipr.inMemory) == 1 {
+			if err := ipr.rlockMemory(); err != nil {
+				return err
+			}
+			copy(index, ipr.dbBinStr[off:off+n*blockLen])
+			ipr.memMu.RUnlock()
+		} else if err := ipr.readAt(index, off, "index blocks"); err != nil {
+			return err
+		}
+
+		for p := int64(0); p < int64(len(index)); p += blockLen {
+			r, err := ipr.blockRange(decode(index, p))
+			if err != nil {
+				return err
+			}
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIp2Region_Iterate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	records := testRecords(10000)
+	ipv6 := []testRecord6{
+		{"2001:db8::", "2001:db8::ffff", "日本|0|0|0|0|0|0"},
+		{"2400::", "24ff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "中国|广东|深圳市|电信|3|44|3"},
+	}
+	path := filepath.Join(dir, "ip.db")
+	if err := writeTestDB(path, records, testDBOptions{ipv6: ipv6}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, a := range []Algorithm{BtreeAlgorithm, MemoryAlgorithm} {
+		s, err := NewSearcher(path, WithAlgorithm(a))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		region := s.(*Ip2Region)
+
+		ranges, err := region.Ranges()
+		if err != nil {
+			t.Fatalf("%v: %v", a, err)
+		}
+		if len(ranges) != len(records)+len(ipv6) {
+			t.Fatalf("%v: got %d ranges, want %d", a, len(ranges), len(records)+len(ipv6))
+		}
+		for i, r := range records {
+			got := ranges[i]
+			if got.Start != long2Addr(r.startIP) || got.End != long2Addr(r.endIP) || got.String() != r.info {
+				t.Fatalf("%v: range %d = %s-%s %v, want %v", a, i, got.Start, got.End, got.IpInfo, r)
+			}
+		}
+		for i, r := range ipv6 {
+			got := ranges[len(records)+i]
+			if got.Start != netip.MustParseAddr(r.startIP) || got.End != netip.MustParseAddr(r.endIP) || got.String() != r.info {
+				t.Fatalf("%v: ipv6 range %d = %s-%s %v, want %v", a, i, got.Start, got.End, got.IpInfo, r)
+			}
+		}
+
+		stop := errors.New("stop")
+		n := 0
+		err = region.Iterate(func(r Range) error {
+			if n++; n == 5 {
+				return stop
+			}
+			return nil
+		})
+		if err != stop || n != 5 {
+			t.Fatalf("%v: Iterate = %v after %d ranges, want stop after 5", a, err, n)
+		}
+		region.Close()
+	}
+}
@@ -0,0 +1,76 @@
+package ip2region
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// maxTrailerLength bounds the trailer returned by Layout.
+const maxTrailerLength = 1024
+
+// Layout describes the parts of a db file, as read by Layout.
+type Layout struct {
+	Size          int64
+	FirstIndexPtr int64
+	LastIndexPtr  int64
+	IndexBlocks   int64
+	IPv6Blocks    int64
+	// Upstream is set for dbs with upstream v1 data blocks.
+	Upstream bool
+	Headers  []HeaderEntry
+	// Sections are sorted by offset.
+	Sections []SectionInfo
+	// Trailer is the free text after the sections, such as
+	// "Created by PPIO at ...".
+	Trailer string
+}
+
+// HeaderEntry is one entry of the header blocks: the start ip of an index
+// block and its pointer.
+type HeaderEntry struct {
+	StartIP  netip.Addr
+	IndexPtr int64
+}
+
+// SectionInfo locates an optional section, Offset is the offset of its
+// payload.
+type SectionInfo struct {
+	Tag    string
+	Offset int64
+	Length int64
+}
+
+// Layout returns the super block, header blocks, sections and trailer of
+// the db.
+func (ipr *Ip2Region) Layout() (Layout, error) {
+	l := Layout{
+		Size:          ipr.dbSize,
+		FirstIndexPtr: ipr.firstIndexPtr,
+		LastIndexPtr:  ipr.lastIndexPtr,
+		IndexBlocks:   ipr.totalBlocks,
+		Upstream:      ipr.upstream,
+	}
+	for i := range ipr.headerSip {
+		l.Headers = append(l.Headers, HeaderEntry{StartIP: long2Addr(ipr.headerSip[i]), IndexPtr: ipr.headerPtr[i]})
+	}
+	for tag, s := range ipr.sections {
+		l.Sections = append(l.Sections, SectionInfo{Tag: tag, Offset: s.ptr, Length: s.length})
+	}
+	sort.Slice(l.Sections, func(i, j int) bool { return l.Sections[i].Offset < l.Sections[j].Offset })
+	if s, ok := ipr.sections[SectionIPv6Index]; ok {
+		l.IPv6Blocks = s.length / IndexBlock6Length
+	}
+
+	n := ipr.dbSize - ipr.trailerPtr
+	if n > maxTrailerLength {
+		n = maxTrailerLength
+	}
+	if n > 0 {
+		b := make([]byte, n)
+		if err := ipr.readAt(b, ipr.trailerPtr, "trailer"); err != nil {
+			return Layout{}, err
+		}
+		l.Trailer = string(b)
+	}
+	return l, nil
+}
@@ -0,0 +1,52 @@
+package ip2region
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIp2Region_Layout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	err = writeTestDB(path, testRecords(1000), testDBOptions{
+		vector: true,
+		ipv6:   []testRecord6{{"2001:db8::", "2001:db8::ffff", "美国|0|0|0|0|0|0"}},
+		meta:   &Metadata{FormatVersion: FormatVersion},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	region, err := New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer region.Close()
+
+	l, err := region.Layout()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if l.IndexBlocks != 1000 || l.IPv6Blocks != 1 || l.LastIndexPtr-l.FirstIndexPtr != 999*IndexBlockLength {
+		t.Fatalf("layout %+v", l)
+	}
+	// one header per 4 KB of index blocks and one for the end
+	if len(l.Headers) != 2+1 || l.Headers[0].IndexPtr != l.FirstIndexPtr || l.Headers[0].StartIP.String() != "0.0.0.0" {
+		t.Fatalf("headers %+v", l.Headers)
+	}
+	var tags []string
+	for _, s := range l.Sections {
+		tags = append(tags, s.Tag)
+	}
+	if len(tags) != 3 || tags[0] != SectionVectorIndex || tags[1] != SectionIPv6Index || tags[2] != SectionMetadata {
+		t.Fatalf("sections %v", tags)
+	}
+	if l.Trailer != "Created by test at 1000" {
+		t.Fatalf("trailer %q", l.Trailer)
+	}
+}
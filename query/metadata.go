@@ -0,0 +1,108 @@
+package ip2region
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The metadata section describes how a db was built as a JSON object, see
+// Metadata. It is written last, after every other section, and its
+// checksum covers all bytes of the db before it.
+const (
+	SectionMetadata = "META"
+
+	// FormatVersion is the newest db format this package can read. Dbs
+	// without a metadata section are version 1.
+	FormatVersion = 2
+
+	checksumPrefix = "sha256:"
+)
+
+// Metadata is the content of the metadata section of a db.
+type Metadata struct {
+	FormatVersion int       `json:"format_version"`
+	BuildTime     time.Time `json:"build_time"`
+
+	// Sources are the datasets the db was built from.
+	Sources []Source `json:"sources,omitempty"`
+	// CodeTables are the region, province and isp code tables used to
+	// fill in the ids.
+	CodeTables []Source `json:"code_tables,omitempty"`
+
+	IPv4Ranges int `json:"ipv4_ranges"`
+	IPv6Ranges int `json:"ipv6_ranges"`
+	DataBlocks int `json:"data_blocks"`
+
+	// DataLayout is DataLayoutUpstream for dbs with upstream v1 data
+	// blocks and empty otherwise.
+	DataLayout string `json:"data_layout,omitempty"`
+
+	// Checksum is "sha256:" and the hex sha256 of the db up to the
+	// metadata section.
+	Checksum string `json:"checksum"`
+}
+
+// Source names a dataset or code table and its version.
+type Source struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// Metadata returns the metadata section of the db, or nil for dbs built
+// without one.
+func (ipr *Ip2Region) Metadata() *Metadata {
+	if ipr.metadata == nil {
+		return nil
+	}
+	md := *ipr.metadata
+	return &md
+}
+
+// readMetadata decodes the metadata section, if any, and refuses dbs
+// written in a newer format.
+func (ipr *Ip2Region) readMetadata() error {
+	s, ok := ipr.sections[SectionMetadata]
+	if !ok {
+		return nil
+	}
+	b := make([]byte, s.length)
+	if err := ipr.readAt(b, s.ptr, "metadata"); err != nil {
+		return err
+	}
+	md, err := decodeMetadata(b)
+	if err != nil {
+		return err
+	}
+	ipr.metadata = md
+	return nil
+}
+
+func decodeMetadata(b []byte) (*Metadata, error) {
+	var md Metadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, corruptf("metadata: %v", err)
+	}
+	if md.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("%w: format version %d, newest supported is %d", ErrUnsupportedFormat, md.FormatVersion, FormatVersion)
+	}
+	return &md, nil
+}
+
+// checksum returns the Metadata.Checksum of the db content read from r.
+func checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return checksumPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumSupported reports whether the algorithm of sum is known.
+func checksumSupported(sum string) bool {
+	return strings.HasPrefix(sum, checksumPrefix)
+}
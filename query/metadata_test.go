@@ -0,0 +1,71 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIp2Region_Metadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+
+	md := Metadata{
+		FormatVersion: FormatVersion,
+		BuildTime:     time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		Sources:       []Source{{Name: "qqwry", Version: "20240501"}},
+		CodeTables:    []Source{{Name: "area_code.csv", Version: "2023"}},
+		IPv4Ranges:    64,
+		DataBlocks:    5,
+	}
+	if err := writeTestDB(path, testRecords(64), testDBOptions{vector: true, meta: &md}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	region, err := New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := region.Metadata()
+	region.Close()
+	if got == nil || got.Checksum == "" {
+		t.Fatalf("metadata %+v", got)
+	}
+	md.Checksum = got.Checksum
+	if !reflect.DeepEqual(*got, md) {
+		t.Fatalf("got %+v, want %+v", *got, md)
+	}
+	if err := Verify(path); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the checksum covers everything before the metadata section
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b[8+TotalHeaderLength]++
+	var verr *VerifyError
+	if err := verifyDB(b); !errors.As(err, &verr) || len(verr.Problems) != 1 {
+		t.Fatalf("expected a checksum problem, got %v", err)
+	}
+
+	md.FormatVersion = FormatVersion + 1
+	if err := writeTestDB(path, testRecords(64), testDBOptions{meta: &md}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := New(path); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+
+	if ipr.Metadata() != nil {
+		t.Fatal("expected no metadata for a db without a metadata section")
+	}
+}
@@ -0,0 +1,56 @@
+package ip2region
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// mmapReader is an io.ReaderAt over a mapped file. Close unmaps it once
+// the reads in progress have finished, later reads fail with os.ErrClosed.
+type mmapReader struct {
+	mu   sync.RWMutex
+	b    []byte
+	file *os.File
+}
+
+// newMmapReader maps f, which is closed by Close.
+func newMmapReader(f *os.File) (*mmapReader, error) {
+	b, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return &mmapReader{b: b, file: f}, nil
+}
+
+func (m *mmapReader) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.b == nil {
+		return 0, os.ErrClosed
+	}
+	if off < 0 || off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *mmapReader) Close() error {
+	m.mu.Lock()
+	if m.b == nil {
+		m.mu.Unlock()
+		return nil
+	}
+	err := munmapFile(m.b)
+	m.b = nil
+	m.mu.Unlock()
+
+	if cerr := m.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package ip2region
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// mmapFile falls back to reading the whole file where mmap is unavailable.
+func mmapFile(f *os.File) ([]byte, error) {
+	return ioutil.ReadAll(f)
+}
+
+func munmapFile(b []byte) error {
+	return nil
+}
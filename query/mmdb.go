@@ -0,0 +1,825 @@
+package ip2region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hokitlee/go-ip2region/internal/cidr"
+	"github.com/hokitlee/go-ip2region/internal/mmdb"
+)
+
+// MaxMind DB files are a binary search tree over the address bits, 16
+// zero bytes, a data section and a metadata map after
+// mmdb.MetadataStart, see https://maxmind.github.io/MaxMind-DB/.
+const (
+	// MMDBDatabaseType is the database_type of the MMDB files written by
+	// the maker.
+	MMDBDatabaseType = "ip2region"
+
+	// the metadata is in the last 128 KB of a file
+	mmdbMetadataMaxSize = 128 * 1024
+	// maps and arrays nested deeper than this are taken as corruption
+	mmdbMaxDepth = 64
+)
+
+// MMDBMapping maps the data records of an MMDB file to IpInfo. Every
+// field is a path of map keys and array indexes separated by dots, such
+// as "subdivisions.0.names.zh-CN"; fields with an empty path or missing
+// from a record are unknown, "0" like in an ip2region db.
+type MMDBMapping struct {
+	Country    string
+	Province   string
+	City       string
+	ISP        string
+	RegionId   string
+	ProvinceId string
+	ISPId      string
+}
+
+// DefaultMMDBMapping reads the MMDB files written by the maker.
+var DefaultMMDBMapping = MMDBMapping{
+	Country:    "country",
+	Province:   "province",
+	City:       "city",
+	ISP:        "isp",
+	RegionId:   "region_id",
+	ProvinceId: "province_id",
+	ISPId:      "isp_id",
+}
+
+// GeoIP2Mapping reads the names in language lang, such as "zh-CN", of the
+// MaxMind GeoIP2 and GeoLite2 City and Country databases.
+func GeoIP2Mapping(lang string) MMDBMapping {
+	return MMDBMapping{
+		Country:  "country.names." + lang,
+		Province: "subdivisions.0.names." + lang,
+		City:     "city.names." + lang,
+		ISP:      "traits.isp",
+	}
+}
+
+// ASNMapping reads the organization of the MaxMind GeoLite2 ASN database
+// as the isp.
+var ASNMapping = MMDBMapping{ISP: "autonomous_system_organization"}
+
+// MMDBMetadata is the metadata of an MMDB file.
+type MMDBMetadata struct {
+	DatabaseType string
+	Description  map[string]string
+	Languages    []string
+	IPVersion    int
+	NodeCount    int
+	RecordSize   int
+	BuildEpoch   uint64
+}
+
+type mmdbOptions struct {
+	mapping *MMDBMapping
+}
+
+// MMDBOption configures OpenMMDB.
+type MMDBOption func(*mmdbOptions)
+
+// WithMMDBMapping sets how data records are mapped to IpInfo. Without it
+// the mapping follows the database type: DefaultMMDBMapping for the files
+// of the maker, ASNMapping for ASN databases and GeoIP2Mapping in zh-CN,
+// or en when the file has no Chinese names, for the others.
+func WithMMDBMapping(m MMDBMapping) MMDBOption {
+	return func(o *mmdbOptions) {
+		o.mapping = &m
+	}
+}
+
+// MMDB searches a MaxMind DB file held in memory with the same methods as
+// Ip2Region. It is safe for concurrent use.
+type MMDB struct {
+	tree     []byte
+	data     mmdbDecoder
+	meta     MMDBMetadata
+	mapping  [7][]string
+	ipv4Root int
+	// bits walked to reach ipv4Root
+	ipv4Bits int
+
+	// decoded data records by offset
+	infoMu sync.RWMutex
+	infos  map[int]IpInfo
+}
+
+var _ RangeSearcher = (*MMDB)(nil)
+
+// OpenMMDB reads the MaxMind DB file at path into memory.
+func OpenMMDB(path string, opts ...MMDBOption) (*MMDB, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMMDBFromBytes(b, opts...)
+}
+
+// NewMMDBFromBytes searches the MaxMind DB in b, which must not be
+// modified afterwards.
+func NewMMDBFromBytes(b []byte, opts ...MMDBOption) (*MMDB, error) {
+	o := mmdbOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	start := len(b) - mmdbMetadataMaxSize
+	if start < 0 {
+		start = 0
+	}
+	i := bytes.LastIndex(b[start:], []byte(mmdb.MetadataStart))
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no MaxMind DB metadata", ErrUnsupportedFormat)
+	}
+	i += start
+
+	m := &MMDB{}
+	if err := m.readMetadata(b[i+len(mmdb.MetadataStart):]); err != nil {
+		return nil, err
+	}
+	treeSize := m.meta.NodeCount * m.meta.RecordSize / 4
+	if treeSize+mmdb.DataSeparator > i {
+		return nil, corruptf("mmdb search tree of %d nodes is past the metadata", m.meta.NodeCount)
+	}
+	m.tree = b[:treeSize]
+	m.data = mmdbDecoder{b: b[treeSize+mmdb.DataSeparator : i]}
+
+	mapping := o.mapping
+	if mapping == nil {
+		mapping = m.defaultMapping()
+	}
+	for k, path := range []string{mapping.Country, mapping.Province, mapping.City, mapping.ISP,
+		mapping.RegionId, mapping.ProvinceId, mapping.ISPId} {
+		if path != "" {
+			m.mapping[k] = strings.Split(path, ".")
+		}
+	}
+
+	// ipv4 addresses are searched from ::/96 of an ipv6 tree
+	if m.meta.IPVersion == 6 {
+		for m.ipv4Bits < 96 && m.ipv4Root < m.meta.NodeCount {
+			m.ipv4Root = m.record(m.ipv4Root, 0)
+			m.ipv4Bits++
+		}
+	}
+	return m, nil
+}
+
+func (m *MMDB) readMetadata(b []byte) error {
+	v, _, err := mmdbDecoder{b: b}.decode(0, 0)
+	if err != nil {
+		return err
+	}
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		return corruptf("mmdb metadata is not a map")
+	}
+	num := func(key string) int {
+		n, _ := mmdbInt(fields[key])
+		return int(n)
+	}
+	if major := num("binary_format_major_version"); major != 2 {
+		return fmt.Errorf("%w: MaxMind DB binary format %d", ErrUnsupportedFormat, major)
+	}
+
+	md := MMDBMetadata{
+		IPVersion:  num("ip_version"),
+		NodeCount:  num("node_count"),
+		RecordSize: num("record_size"),
+	}
+	md.DatabaseType, _ = fields["database_type"].(string)
+	if n, ok := mmdbInt(fields["build_epoch"]); ok {
+		md.BuildEpoch = uint64(n)
+	}
+	if desc, ok := fields["description"].(map[string]interface{}); ok {
+		md.Description = make(map[string]string, len(desc))
+		for k, v := range desc {
+			md.Description[k], _ = v.(string)
+		}
+	}
+	if langs, ok := fields["languages"].([]interface{}); ok {
+		for _, l := range langs {
+			if s, ok := l.(string); ok {
+				md.Languages = append(md.Languages, s)
+			}
+		}
+	}
+	switch {
+	case md.IPVersion != 4 && md.IPVersion != 6:
+		return corruptf("mmdb ip version %d", md.IPVersion)
+	case md.RecordSize != 24 && md.RecordSize != 28 && md.RecordSize != 32:
+		return corruptf("mmdb record size %d", md.RecordSize)
+	case md.NodeCount <= 0:
+		return corruptf("mmdb node count %d", md.NodeCount)
+	}
+	m.meta = md
+	return nil
+}
+
+func (m *MMDB) defaultMapping() *MMDBMapping {
+	switch {
+	case m.meta.DatabaseType == MMDBDatabaseType:
+		return &DefaultMMDBMapping
+	case strings.Contains(m.meta.DatabaseType, "ASN"):
+		return &ASNMapping
+	}
+	lang := "en"
+	for _, l := range m.meta.Languages {
+		if l == "zh-CN" {
+			lang = l
+		}
+	}
+	mapping := GeoIP2Mapping(lang)
+	return &mapping
+}
+
+// Metadata returns the metadata of the MMDB file.
+func (m *MMDB) Metadata() MMDBMetadata {
+	return m.meta
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (m *MMDB) record(node, bit int) int {
+	switch m.meta.RecordSize {
+	case 24:
+		b := m.tree[node*6+bit*3:]
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	case 28:
+		b := m.tree[node*7:]
+		if bit == 0 {
+			return int(b[3]>>4)<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+		return int(b[3]&0x0F)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	default:
+		return int(binary.BigEndian.Uint32(m.tree[node*8+bit*4:]))
+	}
+}
+
+// lookup walks the search tree for addr and returns the offset of its
+// data record in the data section and the prefix length of the network
+// holding it.
+func (m *MMDB) lookup(addr netip.Addr) (off, bits int, err error) {
+	if !addr.IsValid() {
+		return 0, 0, &ParseError{Input: addr.String(), Msg: "invalid address"}
+	}
+	addr = addr.Unmap()
+	key := addr.AsSlice()
+	node := 0
+	if addr.Is4() && m.meta.IPVersion == 6 {
+		node = m.ipv4Root
+	} else if !addr.Is4() && m.meta.IPVersion == 4 {
+		return 0, 0, ErrNotFound
+	}
+
+	nodeCount := m.meta.NodeCount
+	for bits < len(key)*8 && node < nodeCount {
+		node = m.record(node, int(key[bits/8]>>(7-bits%8))&1)
+		bits++
+	}
+	switch {
+	case node == nodeCount:
+		return 0, 0, ErrNotFound
+	case node < nodeCount:
+		return 0, 0, corruptf("mmdb search tree is deeper than %d bits", len(key)*8)
+	}
+	off = node - nodeCount - mmdb.DataSeparator
+	if off < 0 || off >= len(m.data.b) {
+		return 0, 0, corruptf("mmdb record %d points outside the data section", node)
+	}
+	return off, bits, nil
+}
+
+// Search searches an IPv4 or IPv6 address.
+func (m *MMDB) Search(ipStr string) (IpInfo, error) {
+	addr, err := parseAddr(ipStr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	return m.SearchAddr(addr)
+}
+
+// SearchAddr searches an IPv4 or IPv6 address, IPv4-mapped IPv6 addresses
+// are searched as IPv4.
+func (m *MMDB) SearchAddr(addr netip.Addr) (IpInfo, error) {
+	off, _, err := m.lookup(addr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	return m.ipInfo(off)
+}
+
+// SearchIP searches a 4 or 16 byte net.IP.
+func (m *MMDB) SearchIP(ip net.IP) (IpInfo, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return IpInfo{}, &ParseError{Input: ip.String(), Msg: "invalid address"}
+	}
+	return m.SearchAddr(addr)
+}
+
+// SearchRange is Search returning the network of the search tree holding
+// the ip along with its IpInfo. Adjacent networks may share a region.
+func (m *MMDB) SearchRange(ipStr string) (Range, error) {
+	addr, err := parseAddr(ipStr)
+	if err != nil {
+		return Range{}, err
+	}
+	return m.SearchAddrRange(addr)
+}
+
+// SearchAddrRange is SearchAddr returning the network of the search tree
+// holding the ip along with its IpInfo.
+func (m *MMDB) SearchAddrRange(addr netip.Addr) (Range, error) {
+	off, bits, err := m.lookup(addr)
+	if err != nil {
+		return Range{}, err
+	}
+	info, err := m.ipInfo(off)
+	if err != nil {
+		return Range{}, err
+	}
+	p := netip.PrefixFrom(addr.Unmap(), bits).Masked()
+	return Range{Start: p.Addr(), End: cidr.LastAddr(p), IpInfo: info}, nil
+}
+
+// Iterate calls fn for every range of the file in address order, the IPv4
+// ranges first. Adjacent networks mapped to the same IpInfo are joined, and
+// the IPv4-mapped and 6to4 aliases of the IPv4 ranges are skipped.
+func (m *MMDB) Iterate(fn func(r Range) error) error {
+	var pending Range
+	flush := func() error {
+		if !pending.Start.IsValid() {
+			return nil
+		}
+		r := pending
+		pending = Range{}
+		return fn(r)
+	}
+	emit := func(p netip.Prefix, off int) error {
+		info, err := m.ipInfo(off)
+		if err != nil {
+			return err
+		}
+		start, end := p.Addr(), cidr.LastAddr(p)
+		if pending.Start.IsValid() && pending.IpInfo == info && pending.End.Next() == start {
+			pending.End = end
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		pending = Range{Start: start, End: end, IpInfo: info}
+		return nil
+	}
+
+	var walk func(node, depth int, key [16]byte) error
+	walk = func(node, depth int, key [16]byte) error {
+		if depth >= len(key)*8 || (m.meta.IPVersion == 4 && depth >= 32) {
+			return corruptf("mmdb search tree is deeper than the address")
+		}
+		for bit := 0; bit < 2; bit++ {
+			k := key
+			k[depth/8] |= byte(bit << (7 - depth%8))
+			child := m.record(node, bit)
+			p := m.prefix(k, depth+1)
+			switch {
+			case m.meta.IPVersion == 6 && p == mmdbIPv4Mapped:
+				continue
+			case child < m.meta.NodeCount:
+				if child == m.ipv4Root && m.meta.IPVersion == 6 && !(depth+1 == m.ipv4Bits && p.Addr().Is4()) {
+					// an alias of the ipv4 subtree
+					continue
+				}
+				if err := walk(child, depth+1, k); err != nil {
+					return err
+				}
+			case child > m.meta.NodeCount:
+				off := child - m.meta.NodeCount - mmdb.DataSeparator
+				if off < 0 || off >= len(m.data.b) {
+					return corruptf("mmdb record %d points outside the data section", child)
+				}
+				if err := emit(p, off); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(0, 0, [16]byte{}); err != nil {
+		return err
+	}
+	return flush()
+}
+
+var mmdbIPv4Mapped = netip.MustParsePrefix("::ffff:0:0/96")
+
+// prefix returns the network of the first bits bits of key in the tree,
+// the ones below ::/96 of an ipv6 tree as IPv4.
+func (m *MMDB) prefix(key [16]byte, bits int) netip.Prefix {
+	if m.meta.IPVersion == 4 {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{key[0], key[1], key[2], key[3]}), bits)
+	}
+	if bits >= 96 && key == [16]byte{12: key[12], 13: key[13], 14: key[14], 15: key[15]} {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{key[12], key[13], key[14], key[15]}), bits-96)
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(key), bits)
+}
+
+// Ranges returns every range of the file in the order of Iterate.
+func (m *MMDB) Ranges() ([]Range, error) {
+	var ranges []Range
+	err := m.Iterate(func(r Range) error {
+		ranges = append(ranges, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// Export writes every range of the file to w in format, in the order of
+// Iterate.
+func (m *MMDB) Export(w io.Writer, format ExportFormat) error {
+	rw, err := NewRangeWriter(w, format)
+	if err != nil {
+		return err
+	}
+	if err := m.Iterate(rw.Write); err != nil {
+		return err
+	}
+	return rw.Flush()
+}
+
+// Close releases nothing, the file is held in memory, it is there to
+// implement Searcher.
+func (m *MMDB) Close() error {
+	return nil
+}
+
+// ipInfo maps the data record at off.
+func (m *MMDB) ipInfo(off int) (IpInfo, error) {
+	m.infoMu.RLock()
+	info, ok := m.infos[off]
+	m.infoMu.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	var fields [7]interface{}
+	for k, path := range m.mapping {
+		if path == nil {
+			continue
+		}
+		v, err := m.data.lookup(off, path)
+		if err != nil {
+			return IpInfo{}, err
+		}
+		fields[k] = v
+	}
+	str := func(v interface{}) string {
+		switch v := v.(type) {
+		case nil:
+			return "0"
+		case string:
+			if v == "" {
+				return "0"
+			}
+			return v
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	id := func(v interface{}) int64 {
+		if s, ok := v.(string); ok {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			return n
+		}
+		n, _ := mmdbInt(v)
+		return n
+	}
+	info = IpInfo{
+		Country:    str(fields[0]),
+		Province:   str(fields[1]),
+		City:       str(fields[2]),
+		ISP:        str(fields[3]),
+		RegionId:   id(fields[4]),
+		ProvinceId: id(fields[5]),
+		ISPId:      id(fields[6]),
+	}
+
+	m.infoMu.Lock()
+	if m.infos == nil {
+		m.infos = make(map[int]IpInfo)
+	}
+	m.infos[off] = info
+	m.infoMu.Unlock()
+	return info, nil
+}
+
+// mmdbInt returns the integer values of the data section as an int64.
+func mmdbInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), true
+		}
+	case int64:
+		return v, true
+	case *big.Int:
+		if v.IsInt64() {
+			return v.Int64(), true
+		}
+	}
+	return 0, false
+}
+
+// isMMDB reports whether the file at path ends with MaxMind DB metadata.
+func isMMDB(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return isMMDBAt(f, fi.Size())
+}
+
+// isMMDBAt is isMMDB for the size byte input in r.
+func isMMDBAt(r io.ReaderAt, size int64) bool {
+	n := size
+	if n > mmdbMetadataMaxSize {
+		n = mmdbMetadataMaxSize
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, size-n); err != nil && err != io.EOF {
+		return false
+	}
+	return bytes.Contains(b, []byte(mmdb.MetadataStart))
+}
+
+// mmdbDecoder decodes values of an MMDB data section, offsets are
+// relative to its start.
+type mmdbDecoder struct {
+	b []byte
+}
+
+// header decodes the control byte at off, returning the type, the size
+// or, for pointers, the offset pointed to, and the offset of the payload.
+func (d mmdbDecoder) header(off int) (typ, size, next int, err error) {
+	if off < 0 || off >= len(d.b) {
+		return 0, 0, 0, corruptf("mmdb data offset %d outside the data section", off)
+	}
+	ctrl := d.b[off]
+	next = off + 1
+	typ = int(ctrl >> 5)
+	if typ == 0 {
+		if next >= len(d.b) {
+			return 0, 0, 0, corruptf("mmdb data at %d is truncated", off)
+		}
+		typ = 7 + int(d.b[next])
+		next++
+	}
+
+	extra := func(n int) (int, error) {
+		if next+n > len(d.b) {
+			return 0, corruptf("mmdb data at %d is truncated", off)
+		}
+		v := 0
+		for _, c := range d.b[next : next+n] {
+			v = v<<8 | int(c)
+		}
+		next += n
+		return v, nil
+	}
+
+	if typ == mmdb.Pointer {
+		ss, vvv := int(ctrl>>3)&3, int(ctrl&7)
+		v, err := extra(ss + 1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		switch ss {
+		case 0:
+			size = vvv<<8 | v
+		case 1:
+			size = (vvv<<16 | v) + 2048
+		case 2:
+			size = (vvv<<24 | v) + 526336
+		default:
+			size = v
+		}
+		return typ, size, next, nil
+	}
+
+	size = int(ctrl & 0x1F)
+	var v int
+	switch size {
+	case 29:
+		v, err = extra(1)
+		size = 29 + v
+	case 30:
+		v, err = extra(2)
+		size = 285 + v
+	case 31:
+		v, err = extra(3)
+		size = 65821 + v
+	}
+	return typ, size, next, err
+}
+
+// resolve follows the pointer at off, if any.
+func (d mmdbDecoder) resolve(off int) (typ, size, next int, err error) {
+	typ, size, next, err = d.header(off)
+	if err != nil || typ != mmdb.Pointer {
+		return typ, size, next, err
+	}
+	typ, size, next, err = d.header(size)
+	if err == nil && typ == mmdb.Pointer {
+		err = corruptf("mmdb pointer at %d points to a pointer", off)
+	}
+	return typ, size, next, err
+}
+
+// payload returns the size bytes at off.
+func (d mmdbDecoder) payload(off, size int) ([]byte, error) {
+	if off+size > len(d.b) {
+		return nil, corruptf("mmdb data at %d is truncated", off)
+	}
+	return d.b[off : off+size], nil
+}
+
+// decode decodes the value at off, returning the offset after it.
+func (d mmdbDecoder) decode(off, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, corruptf("mmdb data nested deeper than %d", mmdbMaxDepth)
+	}
+	typ, size, next, err := d.header(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == mmdb.Pointer {
+		v, _, err := d.decode(size, depth+1)
+		return v, next, err
+	}
+
+	switch typ {
+	case mmdb.Map:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, n, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, corruptf("mmdb map key at %d is not a string", next)
+			}
+			if m[key], next, err = d.decode(n, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, next, nil
+	case mmdb.Array:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			var v interface{}
+			if v, next, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, next, nil
+	case mmdb.Bool:
+		return size != 0, next, nil
+	case mmdb.Container, mmdb.EndMarker:
+		return nil, next, nil
+	}
+
+	b, err := d.payload(next, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	next += size
+	switch typ {
+	case mmdb.String:
+		return string(b), next, nil
+	case mmdb.Bytes:
+		return append([]byte(nil), b...), next, nil
+	case mmdb.Double:
+		if size != 8 {
+			return nil, 0, corruptf("mmdb double at %d is %d bytes", off, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdb.Float:
+		if size != 4 {
+			return nil, 0, corruptf("mmdb float at %d is %d bytes", off, size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case mmdb.Uint16, mmdb.Uint32, mmdb.Uint64, mmdb.Int32:
+		if size > 8 {
+			return nil, 0, corruptf("mmdb integer at %d is %d bytes", off, size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if typ == mmdb.Int32 {
+			return int64(int32(v)), next, nil
+		}
+		return v, next, nil
+	case mmdb.Uint128:
+		return new(big.Int).SetBytes(b), next, nil
+	}
+	return nil, 0, corruptf("mmdb data at %d has unknown type %d", off, typ)
+}
+
+// skip returns the offset after the value at off.
+func (d mmdbDecoder) skip(off, depth int) (int, error) {
+	if depth > mmdbMaxDepth {
+		return 0, corruptf("mmdb data nested deeper than %d", mmdbMaxDepth)
+	}
+	typ, size, next, err := d.header(off)
+	if err != nil {
+		return 0, err
+	}
+	switch typ {
+	case mmdb.Pointer, mmdb.Bool, mmdb.Container, mmdb.EndMarker:
+		return next, nil
+	case mmdb.Map, mmdb.Array:
+		n := size
+		if typ == mmdb.Map {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			if next, err = d.skip(next, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+	}
+	if next+size > len(d.b) {
+		return 0, corruptf("mmdb data at %d is truncated", off)
+	}
+	return next + size, nil
+}
+
+// lookup decodes the value at path in the record at off, or returns nil
+// when the record has no such value.
+func (d mmdbDecoder) lookup(off int, path []string) (interface{}, error) {
+	for _, key := range path {
+		typ, size, next, err := d.resolve(off)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case mmdb.Map:
+			found := false
+			for i := 0; i < size && !found; i++ {
+				k, n, err := d.decode(next, 0)
+				if err != nil {
+					return nil, err
+				}
+				if s, _ := k.(string); s == key {
+					off, found = n, true
+				} else if next, err = d.skip(n, 0); err != nil {
+					return nil, err
+				}
+			}
+			if !found {
+				return nil, nil
+			}
+		case mmdb.Array:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= size {
+				return nil, nil
+			}
+			for ; i > 0; i-- {
+				if next, err = d.skip(next, 0); err != nil {
+					return nil, err
+				}
+			}
+			off = next
+		default:
+			return nil, nil
+		}
+	}
+	v, _, err := d.decode(off, 0)
+	return v, err
+}
@@ -0,0 +1,178 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMMDB(t *testing.T) {
+	beijing := map[string]interface{}{"country": "中国", "province": "北京", "city": "北京市", "isp": "联通",
+		"region_id": 110000, "province_id": 11, "isp_id": 2}
+	records := []testMMDBRecord{
+		{"1.0.0.0/24", beijing},
+		{"1.0.1.0/24", beijing},
+		{"1.0.2.0/23", map[string]interface{}{"country": "美国"}},
+		{"2001:db8::/32", map[string]interface{}{"country": "日本", "isp": "联通"}},
+	}
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.mmdb")
+	if err := ioutil.WriteFile(path, testMMDB(6, 24, MMDBDatabaseType, records), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	s, err := NewSearcher(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer s.Close()
+	m, ok := s.(*MMDB)
+	if !ok {
+		t.Fatalf("NewSearcher returned a %T", s)
+	}
+	md := m.Metadata()
+	if md.DatabaseType != MMDBDatabaseType || md.IPVersion != 6 || md.RecordSize != 24 || md.BuildEpoch != 1714550400 ||
+		md.Description["en"] != "test" || !reflect.DeepEqual(md.Languages, []string{"en", "zh-CN"}) {
+		t.Fatalf("metadata %+v", md)
+	}
+
+	for _, c := range []struct {
+		ip, want, start, end string
+	}{
+		{"1.0.0.1", "中国|北京|北京市|联通|110000|11|2", "1.0.0.0", "1.0.0.255"},
+		{"::ffff:1.0.1.1", "中国|北京|北京市|联通|110000|11|2", "1.0.1.0", "1.0.1.255"},
+		{"1.0.3.255", "美国|0|0|0|0|0|0", "1.0.2.0", "1.0.3.255"},
+		{"2001:db8::1", "日本|0|0|联通|0|0|0", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"1.0.4.0", "", "", ""},
+		{"2001:db9::", "", "", ""},
+	} {
+		info, err := m.Search(c.ip)
+		if c.want == "" {
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("%s: got %v, %v, want not found", c.ip, info, err)
+			}
+			continue
+		}
+		if err != nil || info.String() != c.want {
+			t.Fatalf("%s: got %v, %v, want %s", c.ip, info, err, c.want)
+		}
+		r, err := m.SearchRange(c.ip)
+		if err != nil || r.Start.String() != c.start || r.End.String() != c.end || r.IpInfo != info {
+			t.Fatalf("%s: range %v, %v", c.ip, r, err)
+		}
+	}
+	if _, err := m.Search("1.0.0"); !errors.Is(err, ErrInvalidIP) {
+		t.Fatalf("expected ErrInvalidIP, got %v", err)
+	}
+
+	ranges, err := m.Ranges()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var got []string
+	for _, r := range ranges {
+		got = append(got, r.Start.String()+"-"+r.End.String()+" "+r.Country)
+	}
+	want := []string{"1.0.0.0-1.0.1.255 中国", "1.0.2.0-1.0.3.255 美国", "2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff 日本"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestMMDB_mapping(t *testing.T) {
+	city := map[string]interface{}{
+		"city":         map[string]interface{}{"geoname_id": 1795565, "names": map[string]interface{}{"en": "Shenzhen", "zh-CN": "深圳"}},
+		"country":      map[string]interface{}{"iso_code": "CN", "names": map[string]interface{}{"en": "China", "zh-CN": "中国"}},
+		"location":     map[string]interface{}{"latitude": 22.5333, "longitude": 114.1333},
+		"subdivisions": []interface{}{map[string]interface{}{"iso_code": "GD", "names": map[string]interface{}{"en": "Guangdong", "zh-CN": "广东"}}},
+		"traits":       map[string]interface{}{"is_anycast": false, "isp": "China Telecom"},
+	}
+	other := map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "CN", "names": map[string]interface{}{"en": "China", "zh-CN": "中国"}},
+	}
+	records := []testMMDBRecord{{"1.0.0.0/24", city}, {"1.0.1.0/24", other}}
+
+	for _, size := range []int{24, 28, 32} {
+		m, err := NewMMDBFromBytes(testMMDB(4, size, "GeoLite2-City", records))
+		if err != nil {
+			t.Fatalf("%d: %v", size, err)
+		}
+		for ip, want := range map[string]string{
+			"1.0.0.1": "中国|广东|深圳|China Telecom|0|0|0",
+			"1.0.1.1": "中国|0|0|0|0|0|0",
+		} {
+			if info, err := m.Search(ip); err != nil || info.String() != want {
+				t.Fatalf("%d: %s: got %v, %v, want %s", size, ip, info, err, want)
+			}
+		}
+		if _, err := m.Search("2001:db8::1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%d: expected not found for ipv6 in an ipv4 file, got %v", size, err)
+		}
+	}
+
+	m, err := NewMMDBFromBytes(testMMDB(4, 24, "GeoLite2-City", records), WithMMDBMapping(MMDBMapping{
+		Country:  "country.iso_code",
+		Province: "subdivisions.0.iso_code",
+		City:     "city.names.en",
+		RegionId: "city.geoname_id",
+		ISPId:    "location.latitude",
+	}))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if info, err := m.Search("1.0.0.1"); err != nil || info.String() != "CN|GD|Shenzhen|0|1795565|0|0" {
+		t.Fatalf("got %v, %v", info, err)
+	}
+}
+
+func TestMMDB_corrupt(t *testing.T) {
+	b := testMMDB(6, 24, MMDBDatabaseType, []testMMDBRecord{{"1.0.0.0/24", map[string]interface{}{"country": "中国"}}})
+
+	if _, err := NewMMDBFromBytes(b[:100]); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat without metadata, got %v", err)
+	}
+	if _, err := NewMMDBFromBytes(b[60:]); !errors.Is(err, ErrCorruptDB) {
+		t.Fatalf("expected ErrCorruptDB for a truncated tree, got %v", err)
+	}
+
+	// point the first record of the root past the data section
+	bad := append([]byte(nil), b...)
+	bad[0], bad[1], bad[2] = 0xff, 0xff, 0xff
+	m, err := NewMMDBFromBytes(bad)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := m.Search("1.0.0.1"); !errors.Is(err, ErrCorruptDB) {
+		t.Fatalf("expected ErrCorruptDB, got %v", err)
+	}
+	if _, err := m.Ranges(); !errors.Is(err, ErrCorruptDB) {
+		t.Fatalf("expected ErrCorruptDB from Ranges, got %v", err)
+	}
+}
+
+func TestMMDB_addr(t *testing.T) {
+	m, err := NewMMDBFromBytes(testMMDB(6, 28, MMDBDatabaseType, []testMMDBRecord{
+		{"0.0.0.0/0", map[string]interface{}{"country": "中国"}},
+	}))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, err := m.SearchAddrRange(netip.MustParseAddr("8.8.8.8"))
+	if err != nil || r.Start.String() != "0.0.0.0" || r.End.String() != "255.255.255.255" {
+		t.Fatalf("got %v, %v", r, err)
+	}
+	if info, err := m.SearchIP(netip.MustParseAddr("8.8.8.8").AsSlice()); err != nil || info.Country != "中国" {
+		t.Fatalf("got %v, %v", info, err)
+	}
+	if _, err := m.SearchAddr(netip.Addr{}); !errors.Is(err, ErrInvalidIP) {
+		t.Fatalf("expected ErrInvalidIP, got %v", err)
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package ip2region
+
+const raceEnabled = false
@@ -0,0 +1,139 @@
+package ip2region
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ParseError is returned for a string that is not a valid ip address.
+type ParseError struct {
+	Input string // the string being parsed
+	Msg   string // what is wrong with it
+}
+
+func (e *ParseError) Error() string {
+	return "ip format error: " + e.Msg + ": " + strconv.Quote(e.Input)
+}
+
+// parseIPv4 parses a strict dotted-quad address: four decimal numbers of
+// at most 255 without signs, spaces or leading zeros.
+func parseIPv4(s string) (int64, *ParseError) {
+	var sum, n int64
+	var parts, digits int
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == '.' {
+			if digits == 0 {
+				return 0, &ParseError{Input: s, Msg: "empty octet"}
+			}
+			if parts++; parts > 4 {
+				return 0, &ParseError{Input: s, Msg: "want 4 octets"}
+			}
+			sum = sum<<8 | n
+			n, digits = 0, 0
+			continue
+		}
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, &ParseError{Input: s, Msg: "unexpected character " + strconv.QuoteRune(rune(c))}
+		}
+		if digits == 1 && n == 0 {
+			return 0, &ParseError{Input: s, Msg: "octet with leading zero"}
+		}
+		n = n*10 + int64(c-'0')
+		digits++
+		if n > 255 {
+			return 0, &ParseError{Input: s, Msg: "octet out of range"}
+		}
+	}
+	if parts != 4 {
+		return 0, &ParseError{Input: s, Msg: "want 4 octets"}
+	}
+	return sum, nil
+}
+
+// parseIP parses ipStr into either an IPv4 address as a long or, when it is
+// a genuine IPv6 address, its 16 byte form.
+func parseIP(ipStr string) (ip int64, ip6 net.IP, err error) {
+	if strings.IndexByte(ipStr, ':') < 0 {
+		ip, err = Ip2long(ipStr)
+		return
+	}
+	addr, perr := netip.ParseAddr(ipStr)
+	if perr != nil {
+		return 0, nil, &ParseError{Input: ipStr, Msg: "invalid ipv6 address"}
+	}
+	if addr.Zone() != "" {
+		return 0, nil, &ParseError{Input: ipStr, Msg: "unexpected zone"}
+	}
+	if addr.Is4In6() {
+		b := addr.As4()
+		return int64(b[0])<<24 | int64(b[1])<<16 | int64(b[2])<<8 | int64(b[3]), nil, nil
+	}
+	b := addr.As16()
+	return 0, b[:], nil
+}
+
+// parseAddr is parseIP returning a netip.Addr, IPv4-mapped addresses are
+// unmapped.
+func parseAddr(ipStr string) (netip.Addr, error) {
+	ip, ip6, err := parseIP(ipStr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if ip6 == nil {
+		return long2Addr(ip), nil
+	}
+	var b [16]byte
+	copy(b[:], ip6)
+	return netip.AddrFrom16(b), nil
+}
+
+// NormalizeAddr parses the ip forms commonly found in logs, which Ip2long
+// and the search methods reject: surrounding whitespace, a port as in
+// "1.2.3.4:443" or "[2001:db8::1]:443", brackets as in "[2001:db8::1]" and
+// an IPv6 zone. IPv4-mapped IPv6 addresses such as "::ffff:1.2.3.4" are
+// returned as IPv4, so the result can be passed to SearchAddr directly.
+func NormalizeAddr(s string) (netip.Addr, error) {
+	host := strings.TrimSpace(s)
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end < 0 {
+			return netip.Addr{}, &ParseError{Input: s, Msg: "missing ']'"}
+		}
+		if rest := host[end+1:]; rest != "" && !isPort(rest) {
+			return netip.Addr{}, &ParseError{Input: s, Msg: "invalid port"}
+		}
+		host = host[1:end]
+	} else if i := strings.LastIndexByte(host, ':'); i >= 0 && strings.IndexByte(host, '.') >= 0 && strings.IndexByte(host, ':') == i {
+		// a single colon after a dotted quad can only be a port
+		if !isPort(host[i:]) {
+			return netip.Addr{}, &ParseError{Input: s, Msg: "invalid port"}
+		}
+		host = host[:i]
+	}
+
+	if strings.IndexByte(host, ':') < 0 {
+		ip, err := parseIPv4(host)
+		if err != nil {
+			err.Input = s
+			return netip.Addr{}, err
+		}
+		return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}), nil
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, &ParseError{Input: s, Msg: "invalid ipv6 address"}
+	}
+	return addr.WithZone("").Unmap(), nil
+}
+
+// isPort reports whether s is ":" followed by a port number.
+func isPort(s string) bool {
+	if len(s) < 2 || len(s) > 6 || s[0] != ':' {
+		return false
+	}
+	n, err := strconv.Atoi(s[1:])
+	return err == nil && s[1] != '+' && s[1] != '-' && n <= 65535
+}
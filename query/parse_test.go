@@ -0,0 +1,84 @@
+package ip2region
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestIp2long(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want int64
+	}{
+		{"0.0.0.0", 0},
+		{"1.2.3.4", 0x01020304},
+		{"127.0.0.1", 0x7f000001},
+		{"255.255.255.255", 0xffffffff},
+	} {
+		got, err := Ip2long(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("Ip2long(%q) = %d, %v; want %d", c.in, got, err, c.want)
+		}
+	}
+
+	for _, in := range []string{
+		"", "1.2.3", "1.2.3.4.5", "1.2.3.x", "300.1.1.1", "-1.0.0.0", "+1.0.0.0",
+		"1..2.3", "1.2.3.4.", " 1.2.3.4", "1.2.3.4:80", "01.2.3.4", "1.2.3.256",
+		"::ffff:1.2.3.4", "1.2.3.4444",
+	} {
+		_, err := Ip2long(in)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Input != in {
+			t.Errorf("Ip2long(%q) = %v, want *ParseError", in, err)
+		}
+	}
+}
+
+func TestNormalizeAddr(t *testing.T) {
+	for _, c := range []struct {
+		in, want string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{" 1.2.3.4\n", "1.2.3.4"},
+		{"1.2.3.4:443", "1.2.3.4"},
+		{"[1.2.3.4]:443", "1.2.3.4"},
+		{"::ffff:1.2.3.4", "1.2.3.4"},
+		{"[::ffff:1.2.3.4]:8080", "1.2.3.4"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+	} {
+		got, err := NormalizeAddr(c.in)
+		if err != nil || got != netip.MustParseAddr(c.want) {
+			t.Errorf("NormalizeAddr(%q) = %v, %v; want %s", c.in, got, err, c.want)
+		}
+	}
+
+	for _, in := range []string{
+		"", "1.2.3.x", "300.1.1.1", "1.2.3.4:", "1.2.3.4:http", "1.2.3.4:70000",
+		"[2001:db8::1", "[2001:db8::1]443", "2001:db8::g", "1.2.3.4:-1",
+	} {
+		_, err := NormalizeAddr(in)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("NormalizeAddr(%q) = %v, want *ParseError", in, err)
+		}
+	}
+}
+
+func TestIp2Region_SearchRejectsInvalid(t *testing.T) {
+	for _, in := range []string{"1.2.3.x", "300.1.1.1", "-1.0.0.0", "1.2.3.4:443", "fe80::1%eth0"} {
+		for name, search := range map[string]func(string) (IpInfo, error){
+			"memory": ipr.MemorySearch,
+			"binary": ipr.BinarySearch,
+			"btree":  ipr.BtreeSearch,
+		} {
+			var perr *ParseError
+			if _, err := search(in); !errors.As(err, &perr) {
+				t.Errorf("%s(%q) = %v, want *ParseError", name, in, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package ip2region
+
+// raceEnabled is set in race builds, in which sync.Pool drops items at
+// random and the pooled searches may allocate.
+const raceEnabled = true
@@ -0,0 +1,77 @@
+package ip2region
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/hokitlee/go-ip2region/internal/cidr"
+)
+
+// Range is a range of addresses of the db that share one region. Every
+// address between Start and End, both included, has the same IpInfo, so a
+// search result can be cached for the whole range.
+type Range struct {
+	Start netip.Addr
+	End   netip.Addr
+	IpInfo
+}
+
+// Contains reports whether addr is in the range. IPv4-mapped IPv6
+// addresses are treated as IPv4.
+func (r Range) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.BitLen() == r.Start.BitLen() && r.Start.Compare(addr) <= 0 && addr.Compare(r.End) <= 0
+}
+
+// Prefixes returns the smallest list of CIDR prefixes that exactly covers
+// the range, in address order.
+func (r Range) Prefixes() []netip.Prefix {
+	return cidr.Prefixes(r.Start, r.End)
+}
+
+// SearchRange is Search returning the matched range along with its IpInfo.
+func (ipr *Ip2Region) SearchRange(ipStr string) (Range, error) {
+	ip, ip6, err := parseIP(ipStr)
+	if err != nil {
+		return Range{}, err
+	}
+	var blk indexBlock
+	if ip6 != nil {
+		blk, err = ipr.lookup6(ip6)
+	} else {
+		blk, err = ipr.lookup(ip)
+	}
+	if err != nil {
+		return Range{}, err
+	}
+	return ipr.blockRange(blk)
+}
+
+// SearchAddrRange is SearchAddr returning the matched range along with its
+// IpInfo.
+func (ipr *Ip2Region) SearchAddrRange(addr netip.Addr) (Range, error) {
+	if !addr.IsValid() {
+		return Range{}, &ParseError{Input: addr.String(), Msg: "invalid address"}
+	}
+	var blk indexBlock
+	var err error
+	if addr.Is4() || addr.Is4In6() {
+		b := addr.As4()
+		blk, err = ipr.lookup(int64(binary.BigEndian.Uint32(b[:])))
+	} else {
+		b := addr.As16()
+		blk, err = ipr.lookup6(b[:])
+	}
+	if err != nil {
+		return Range{}, err
+	}
+	return ipr.blockRange(blk)
+}
+
+func (ipr *Ip2Region) blockRange(blk indexBlock) (Range, error) {
+	info, err := ipr.ipInfo(blk.dataPtr)
+	if err != nil {
+		return Range{}, err
+	}
+	return Range{Start: blk.startIP, End: blk.endIP, IpInfo: info}, nil
+}
@@ -0,0 +1,75 @@
+package ip2region
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIp2Region_SearchRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ip.db")
+	err = writeTestDB(path, testRecords(1000), testDBOptions{
+		vector: true,
+		ipv6:   []testRecord6{{"2001:db8::", "2001:db8::ffff", "日本|0|0|0|0|0|0"}},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, a := range []Algorithm{BtreeAlgorithm, BinaryAlgorithm, MemoryAlgorithm} {
+		s, err := NewSearcher(path, WithAlgorithm(a))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		region := s.(*Ip2Region)
+
+		for i := 0; i < 200; i++ {
+			ip := IpLong2String(rand.Int63n(1 << 32))
+			r, err := region.SearchRange(ip)
+			if err != nil {
+				t.Fatalf("%v: SearchRange(%s): %v", a, ip, err)
+			}
+			addr := netip.MustParseAddr(ip)
+			if !r.Contains(addr) {
+				t.Fatalf("%v: range %s-%s does not contain %s", a, r.Start, r.End, ip)
+			}
+			info, _ := region.Search(ip)
+			if r.IpInfo != info {
+				t.Fatalf("%v: %s: range info %v, want %v", a, ip, r.IpInfo, info)
+			}
+			// every address of the range has the same range
+			for _, other := range []netip.Addr{r.Start, r.End} {
+				o, err := region.SearchAddrRange(other)
+				if err != nil || o != r {
+					t.Fatalf("%v: SearchAddrRange(%s) = %v, %v; want %v", a, other, o, err, r)
+				}
+			}
+			covered := false
+			for _, p := range r.Prefixes() {
+				covered = covered || p.Contains(addr)
+			}
+			if !covered {
+				t.Fatalf("%v: prefixes %v do not cover %s", a, r.Prefixes(), ip)
+			}
+		}
+
+		r, err := region.SearchRange("2001:db8::1")
+		if err != nil || r.Country != "日本" || r.Start != netip.MustParseAddr("2001:db8::") ||
+			len(r.Prefixes()) != 1 || r.Prefixes()[0].String() != "2001:db8::/112" {
+			t.Fatalf("%v: ipv6 range %v, %v", a, r, err)
+		}
+		if _, err := region.SearchRange("2001:db9::1"); err != ErrNotFound {
+			t.Fatalf("%v: got %v, want ErrNotFound", a, err)
+		}
+		region.Close()
+	}
+}
@@ -0,0 +1,121 @@
+package ip2region
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+// NewFromReaderAt reads the super block and header blocks of the size byte
+// db in r. Searches read r like the file modes read the db file, use
+// LoadToMemory to copy it into memory. Close does not close r. MaxMind DB
+// and upstream xdb input is rejected with ErrUnsupportedFormat, open it
+// with NewSearcherFromReaderAt.
+func NewFromReaderAt(r io.ReaderAt, size int64) (*Ip2Region, error) {
+	if isMMDBAt(r, size) || isXdb(r, size) {
+		return nil, fmt.Errorf("%w: not an ip2region db, use NewSearcherFromReaderAt", ErrUnsupportedFormat)
+	}
+	ipr := &Ip2Region{
+		reader: r,
+		dbSize: size,
+	}
+	if err := ipr.init(); err != nil {
+		return nil, err
+	}
+	return ipr, nil
+}
+
+// NewFromBytes searches the db in b, which must not be modified
+// afterwards. Every search method runs against b directly, as if it had
+// been loaded by LoadToMemory.
+func NewFromBytes(b []byte) (*Ip2Region, error) {
+	ipr, err := NewFromReaderAt(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	ipr.loadOnce.Do(func() {
+		ipr.dbBinStr = b
+		atomic.StoreInt32(&ipr.inMemory, 1)
+	})
+	return ipr, nil
+}
+
+// NewFromFS opens the db name in fsys, such as an embed.FS. Files that
+// implement io.ReaderAt are searched in place and closed by Close, others
+// are read into memory.
+func NewFromFS(fsys fs.FS, name string) (*Ip2Region, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ra, ok := f.(io.ReaderAt); ok {
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ipr, err := NewFromReaderAt(ra, fi.Size())
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ipr.dbFile = name
+		ipr.closer = f
+		return ipr, nil
+	}
+
+	b, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	ipr, err := NewFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	ipr.dbFile = name
+	return ipr, nil
+}
+
+// NewSearcherFromReaderAt is NewSearcher for the size byte db in r. The
+// mmap algorithm needs a db file and is not supported. MaxMind DB input is
+// read into memory and opened with NewMMDBFromBytes, upstream xdb files
+// with NewXdbFromReaderAt.
+func NewSearcherFromReaderAt(r io.ReaderAt, size int64, opts ...Option) (Searcher, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if isMMDBAt(r, size) {
+		b := make([]byte, size)
+		if err := readFullAt(r, b, 0, "MaxMind DB"); err != nil {
+			return nil, err
+		}
+		return NewMMDBFromBytes(b)
+	}
+	if isXdb(r, size) {
+		return NewXdbFromReaderAt(r, size, opts...)
+	}
+
+	switch o.algorithm {
+	case BtreeAlgorithm, BinaryAlgorithm, MemoryAlgorithm:
+	default:
+		return nil, fmt.Errorf("ip2region: algorithm %v is not supported for an io.ReaderAt", o.algorithm)
+	}
+	ipr, err := NewFromReaderAt(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if o.algorithm == MemoryAlgorithm {
+		if err := ipr.LoadToMemory(); err != nil {
+			return nil, err
+		}
+	}
+	ipr.algorithm = o.algorithm
+	ipr.codes = o.codes
+	return ipr, nil
+}
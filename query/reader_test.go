@@ -0,0 +1,116 @@
+package ip2region
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// readOnlyFS hides the io.ReaderAt of the files of an fs.FS.
+type readOnlyFS struct{ fs.FS }
+
+type readOnlyFile struct{ fs.File }
+
+func (f readOnlyFS) Open(name string) (fs.File, error) {
+	file, err := f.FS.Open(name)
+	return readOnlyFile{file}, err
+}
+
+func TestNewFrom(t *testing.T) {
+	b, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	dir, name := filepath.Split(dbPath)
+	mapFS := fstest.MapFS{"ip2region.db": &fstest.MapFile{Data: b}}
+
+	opens := map[string]func() (*Ip2Region, error){
+		"bytes":      func() (*Ip2Region, error) { return NewFromBytes(b) },
+		"reader at":  func() (*Ip2Region, error) { return NewFromReaderAt(bytes.NewReader(b), int64(len(b))) },
+		"dir fs":     func() (*Ip2Region, error) { return NewFromFS(os.DirFS(dir), name) },
+		"map fs":     func() (*Ip2Region, error) { return NewFromFS(mapFS, "ip2region.db") },
+		"no read at": func() (*Ip2Region, error) { return NewFromFS(readOnlyFS{mapFS}, "ip2region.db") },
+	}
+	for how, open := range opens {
+		region, err := open()
+		if err != nil {
+			t.Fatalf("%s: %v", how, err)
+		}
+		for i := 0; i < 200; i++ {
+			ip := IpLong2String(rand.Int63n(1 << 32))
+			want, _ := ipr.BtreeSearch(ip)
+			for _, search := range []func(string) (IpInfo, error){
+				region.BtreeSearch, region.BinarySearch, region.Search,
+			} {
+				got, err := search(ip)
+				if err != nil || got != want {
+					t.Fatalf("%s: %s = %v, %v; want %v", how, ip, got, err, want)
+				}
+			}
+		}
+		if err := region.LoadToMemory(); err != nil {
+			t.Fatalf("%s: %v", how, err)
+		}
+		if _, err := region.MemorySearch("1.2.3.4"); err != nil {
+			t.Fatalf("%s: %v", how, err)
+		}
+		if err := region.Close(); err != nil {
+			t.Fatalf("%s: %v", how, err)
+		}
+	}
+
+	if _, err := NewFromBytes(b[:len(b)/2]); !errors.Is(err, ErrCorruptDB) {
+		t.Fatalf("expected ErrCorruptDB for a truncated db, got %v", err)
+	}
+	if _, err := NewFromFS(mapFS, "missing.db"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestNewSearcherFromReaderAt(t *testing.T) {
+	b, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, a := range []Algorithm{BtreeAlgorithm, BinaryAlgorithm, MemoryAlgorithm} {
+		s, err := NewSearcherFromReaderAt(bytes.NewReader(b), int64(len(b)), WithAlgorithm(a))
+		if err != nil {
+			t.Fatalf("%v: %v", a, err)
+		}
+		want, _ := ipr.BtreeSearch("1.2.3.4")
+		if got, err := s.Search("1.2.3.4"); err != nil || got != want {
+			t.Fatalf("%v: got %v, %v; want %v", a, got, err, want)
+		}
+		s.Close()
+	}
+	if _, err := NewSearcherFromReaderAt(bytes.NewReader(b), int64(len(b)), WithMmap()); err == nil {
+		t.Fatal("expected an error for the mmap algorithm")
+	}
+}
+
+func TestNewSearcherFromReaderAt_mmdb(t *testing.T) {
+	b := testMMDB(6, 24, MMDBDatabaseType, []testMMDBRecord{
+		{"1.0.0.0/24", map[string]interface{}{"country": "中国", "city": "北京市"}},
+	})
+	s, err := NewSearcherFromReaderAt(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer s.Close()
+	if _, ok := s.(*MMDB); !ok {
+		t.Fatalf("NewSearcherFromReaderAt returned a %T", s)
+	}
+	if got, err := s.Search("1.0.0.1"); err != nil || got.City != "北京市" {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	if _, err := NewFromBytes(b); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat for a MaxMind DB, got %v", err)
+	}
+}
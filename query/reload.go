@@ -0,0 +1,259 @@
+package ip2region
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reloader is a Searcher over a db file that is replaced while in use.
+// Reload opens the new file in the background, checks it and swaps it in
+// atomically; the previous Ip2Region is closed once the searches running
+// on it have finished, so no lookup fails because of a reload.
+//
+// Only ip2region dbs can be reloaded, MaxMind DB and xdb files are
+// rejected with ErrUnsupportedFormat. The db file should be replaced by
+// renaming a complete file over it, a file rewritten in place can be read
+// half written.
+type Reloader struct {
+	path string
+	opts []Option
+
+	// serializes Reload and Close
+	mu      sync.Mutex
+	cur     atomic.Value // *generation
+	modTime time.Time
+	size    int64
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var _ Searcher = (*Reloader)(nil)
+
+// generation is one loaded db, refs counts the searches using it.
+type generation struct {
+	ipr       *Ip2Region
+	refs      int64
+	retired   int32
+	closeOnce sync.Once
+}
+
+func (g *generation) release() {
+	if atomic.AddInt64(&g.refs, -1) == 0 && atomic.LoadInt32(&g.retired) == 1 {
+		g.close()
+	}
+}
+
+// retire marks g as replaced and closes it when no search is using it.
+func (g *generation) retire() {
+	atomic.StoreInt32(&g.retired, 1)
+	if atomic.LoadInt64(&g.refs) == 0 {
+		g.close()
+	}
+}
+
+func (g *generation) close() {
+	g.closeOnce.Do(func() {
+		g.ipr.Close()
+	})
+}
+
+// NewReloader opens the db at path with NewSearcher and opts, which are
+// used again by every Reload. It returns an error wrapping
+// ErrUnsupportedFormat if path is a MaxMind DB or xdb file.
+func NewReloader(path string, opts ...Option) (*Reloader, error) {
+	if isMMDB(path) || isXdbFile(path) {
+		return nil, fmt.Errorf("%w: %s is not an ip2region db and cannot be reloaded", ErrUnsupportedFormat, path)
+	}
+	r := &Reloader{path: path, opts: opts}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload opens the db file again and, if it can be searched, swaps it in
+// for the current one. On error the current db stays in use.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	s, err := NewSearcher(r.path, r.opts...)
+	if err != nil {
+		return err
+	}
+	ipr, ok := s.(*Ip2Region)
+	if !ok {
+		s.Close()
+		return fmt.Errorf("%w: %s is not an ip2region db and cannot be reloaded", ErrUnsupportedFormat, r.path)
+	}
+	if err := validate(ipr); err != nil {
+		ipr.Close()
+		return err
+	}
+
+	r.modTime, r.size = fi.ModTime(), fi.Size()
+	if old, ok := r.cur.Load().(*generation); ok {
+		r.cur.Store(&generation{ipr: ipr})
+		old.retire()
+	} else {
+		r.cur.Store(&generation{ipr: ipr})
+	}
+	return nil
+}
+
+// validate checks that the first and last IPv4 ranges of a freshly opened
+// db can be found again by searching them.
+func validate(ipr *Ip2Region) error {
+	if ipr.totalBlocks == 0 {
+		return nil
+	}
+	for _, ptr := range []int64{ipr.firstIndexPtr, ipr.lastIndexPtr} {
+		b := make([]byte, IndexBlockLength)
+		if err := ipr.readAt(b, ptr, "index block"); err != nil {
+			return err
+		}
+		want, err := ipr.blockRange(indexBlockAt(b, 0))
+		if err != nil {
+			return err
+		}
+		got, err := ipr.SearchAddrRange(want.Start)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return corruptf("range %s-%s is not found by searching it", want.Start, want.End)
+		}
+	}
+	return nil
+}
+
+// Watch polls the modification time and size of the db file every
+// interval and reloads it when either changes, until Close. Reload errors
+// are passed to onError if it is not nil; the previous db stays in use.
+func (r *Reloader) Watch(interval time.Duration, onError func(error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.stop != nil {
+		return
+	}
+	r.stop, r.done = make(chan struct{}), make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+			if err := r.reloadIfChanged(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+}
+
+func (r *Reloader) reloadIfChanged() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	changed := !fi.ModTime().Equal(r.modTime) || fi.Size() != r.size
+	r.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return r.Reload()
+}
+
+// acquire returns the current generation with a reference held on it.
+func (r *Reloader) acquire() *generation {
+	for {
+		g := r.cur.Load().(*generation)
+		atomic.AddInt64(&g.refs, 1)
+		// if g was swapped out before the reference was taken its
+		// retire may already have closed it, try the new one
+		if r.cur.Load().(*generation) == g {
+			return g
+		}
+		g.release()
+	}
+}
+
+// Search searches the current db.
+func (r *Reloader) Search(ipStr string) (IpInfo, error) {
+	g := r.acquire()
+	defer g.release()
+	return g.ipr.Search(ipStr)
+}
+
+// SearchUint32 searches the current db.
+func (r *Reloader) SearchUint32(ip uint32) (IpInfo, error) {
+	g := r.acquire()
+	defer g.release()
+	return g.ipr.SearchUint32(ip)
+}
+
+// SearchAddr searches the current db.
+func (r *Reloader) SearchAddr(addr netip.Addr) (IpInfo, error) {
+	g := r.acquire()
+	defer g.release()
+	return g.ipr.SearchAddr(addr)
+}
+
+// SearchRange searches the current db.
+func (r *Reloader) SearchRange(ipStr string) (Range, error) {
+	g := r.acquire()
+	defer g.release()
+	return g.ipr.SearchRange(ipStr)
+}
+
+// SearchAddrRange searches the current db.
+func (r *Reloader) SearchAddrRange(addr netip.Addr) (Range, error) {
+	g := r.acquire()
+	defer g.release()
+	return g.ipr.SearchAddrRange(addr)
+}
+
+// Metadata returns the metadata of the current db.
+func (r *Reloader) Metadata() *Metadata {
+	g := r.acquire()
+	defer g.release()
+	return g.ipr.Metadata()
+}
+
+// Close stops Watch and closes the current db once its searches finish.
+// The Reloader must not be used afterwards.
+func (r *Reloader) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	stop, done := r.stop, r.done
+	r.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	r.cur.Load().(*generation).retire()
+	return nil
+}
@@ -0,0 +1,174 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// replaceTestDB writes a db with every range in country and renames it
+// over path, the way a db should be deployed.
+func replaceTestDB(t *testing.T, path, country string) {
+	records := testRecords(64)
+	for i := range records {
+		records[i].info = country + "|0|0|0|0|0|0"
+	}
+	tmp := path + ".tmp"
+	if err := writeTestDB(tmp, records, testDBOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	replaceTestDB(t, path, "A")
+
+	for _, a := range []Algorithm{BtreeAlgorithm, MemoryAlgorithm, MmapAlgorithm} {
+		r, err := NewReloader(path, WithAlgorithm(a))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		first := r.cur.Load().(*generation)
+
+		var wg sync.WaitGroup
+		var stop, failures int32
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for atomic.LoadInt32(&stop) == 0 {
+					if _, err := r.Search("1.2.3.4"); err != nil {
+						atomic.AddInt32(&failures, 1)
+					}
+				}
+			}()
+		}
+
+		for _, country := range []string{"B", "C", "D"} {
+			replaceTestDB(t, path, country)
+			if err := r.Reload(); err != nil {
+				t.Fatalf("%v: %v", a, err)
+			}
+			info, err := r.Search("1.2.3.4")
+			if err != nil || info.Country != country {
+				t.Fatalf("%v: got %v, %v after reload, want %s", a, info, err, country)
+			}
+		}
+
+		// a broken file does not replace the working db
+		if err := ioutil.WriteFile(path+".tmp", []byte("broken"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := r.Reload(); err == nil {
+			t.Fatalf("%v: reloading a broken db should fail", a)
+		}
+		if info, err := r.Search("1.2.3.4"); err != nil || info.Country != "D" {
+			t.Fatalf("%v: got %v, %v after a failed reload", a, info, err)
+		}
+
+		atomic.StoreInt32(&stop, 1)
+		wg.Wait()
+		if failures != 0 {
+			t.Fatalf("%v: %d searches failed during reloads", a, failures)
+		}
+		if _, err := first.ipr.Search("1.2.3.4"); !errors.Is(err, os.ErrClosed) && a != MemoryAlgorithm {
+			t.Fatalf("%v: the first db should have been closed, got %v", a, err)
+		}
+		r.Close()
+		if err := r.Reload(); !errors.Is(err, os.ErrClosed) {
+			t.Fatalf("%v: Reload after Close = %v", a, err)
+		}
+		replaceTestDB(t, path, "A")
+	}
+}
+
+func TestReloader_Watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	replaceTestDB(t, path, "A")
+
+	r, err := NewReloader(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer r.Close()
+	r.Watch(5*time.Millisecond, func(err error) { t.Errorf("reload: %v", err) })
+
+	replaceTestDB(t, path, "B")
+	// make sure the change is visible even with a coarse mtime
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("%v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := r.Search("1.2.3.4")
+		if err == nil && info.Country == "B" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("db not reloaded, got %v, %v", info, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReloader_unsupported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"ip.mmdb": testMMDB(4, 24, MMDBDatabaseType, []testMMDBRecord{{"0.0.0.0/0", map[string]interface{}{"country": "A"}}}),
+		"ip.xdb":  testXdb(xdbRecords()),
+	}
+	for name, b := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := NewReloader(path); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%s: got %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+
+	// a db replaced by another format is not swapped in
+	path := filepath.Join(dir, "ip.db")
+	replaceTestDB(t, path, "A")
+	r, err := NewReloader(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer r.Close()
+	if err := os.Rename(filepath.Join(dir, "ip.xdb"), path); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := r.Reload(); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("got %v, want ErrUnsupportedFormat", err)
+	}
+	if info, err := r.Search("1.2.3.4"); err != nil || info.Country != "A" {
+		t.Fatalf("got %v, %v after a failed reload", info, err)
+	}
+}
@@ -0,0 +1,269 @@
+package ip2region
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultRemoteBlockSize   = 16 * 1024
+	defaultRemoteCacheBlocks = 256
+)
+
+// ErrRangeNotSupported is returned when a server ignores HTTP Range
+// requests.
+var ErrRangeNotSupported = errors.New("ip2region: server does not support range requests")
+
+type remoteOptions struct {
+	client      *http.Client
+	blockSize   int64
+	cacheBlocks int
+}
+
+// RemoteOption configures an HTTPReaderAt.
+type RemoteOption func(*remoteOptions)
+
+// WithHTTPClient sets the client used for the range requests, by default
+// http.DefaultClient.
+func WithHTTPClient(c *http.Client) RemoteOption {
+	return func(o *remoteOptions) {
+		o.client = c
+	}
+}
+
+// WithBlockSize sets the size of the blocks the db is fetched and cached
+// in, 16 KB by default.
+func WithBlockSize(n int) RemoteOption {
+	return func(o *remoteOptions) {
+		o.blockSize = int64(n)
+	}
+}
+
+// WithCacheBlocks sets how many blocks are kept in memory, 256 by
+// default.
+func WithCacheBlocks(n int) RemoteOption {
+	return func(o *remoteOptions) {
+		o.cacheBlocks = n
+	}
+}
+
+// HTTPReaderAt reads a file served over HTTP with Range requests. The
+// file is fetched in fixed size blocks, the most recently used of which
+// are cached. It is safe for concurrent use.
+type HTTPReaderAt struct {
+	url  string
+	size int64
+	etag string
+	opt  remoteOptions
+
+	mu     sync.Mutex
+	ll     *list.List
+	blocks map[int64]*list.Element
+}
+
+type remoteBlock struct {
+	n    int64
+	data []byte
+}
+
+// NewHTTPReaderAt requests the first byte of url to learn its size and
+// check that the server supports Range requests.
+func NewHTTPReaderAt(url string, opts ...RemoteOption) (*HTTPReaderAt, error) {
+	r := &HTTPReaderAt{
+		url: url,
+		opt: remoteOptions{
+			client:      http.DefaultClient,
+			blockSize:   defaultRemoteBlockSize,
+			cacheBlocks: defaultRemoteCacheBlocks,
+		},
+		ll:     list.New(),
+		blocks: make(map[int64]*list.Element),
+	}
+	for _, opt := range opts {
+		opt(&r.opt)
+	}
+	if r.opt.blockSize <= 0 || r.opt.cacheBlocks <= 0 {
+		return nil, fmt.Errorf("ip2region: invalid block size %d or cache blocks %d", r.opt.blockSize, r.opt.cacheBlocks)
+	}
+
+	resp, err := r.get(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndexByte(cr, '/')
+	if i < 0 {
+		return nil, fmt.Errorf("ip2region: %s: bad Content-Range %q", url, cr)
+	}
+	if r.size, err = strconv.ParseInt(cr[i+1:], 10, 64); err != nil {
+		return nil, fmt.Errorf("ip2region: %s: bad Content-Range %q", url, cr)
+	}
+	r.etag = resp.Header.Get("ETag")
+	return r, nil
+}
+
+// NewRemote searches the db at url through an HTTPReaderAt. The super
+// block and header blocks are fetched once, searches fetch the index and
+// data blocks they touch.
+func NewRemote(url string, opts ...RemoteOption) (*Ip2Region, error) {
+	r, err := NewHTTPReaderAt(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ipr, err := NewFromReaderAt(r, r.Size())
+	if err != nil {
+		return nil, err
+	}
+	ipr.dbFile = url
+	ipr.closer = r
+	return ipr, nil
+}
+
+// Size returns the size of the remote file.
+func (r *HTTPReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads len(p) bytes at off, fetching the blocks that are not
+// cached with one request per run of missing blocks.
+func (r *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("ip2region: negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+
+	bs := r.opt.blockSize
+	first, last := off/bs, (end-1)/bs
+	blocks := make([][]byte, last-first+1)
+	for n := first; n <= last; n++ {
+		blocks[n-first] = r.cached(n)
+	}
+	for i := 0; i < len(blocks); {
+		if blocks[i] != nil {
+			i++
+			continue
+		}
+		j := i
+		for j < len(blocks) && blocks[j] == nil {
+			j++
+		}
+		if err := r.fetch(first+int64(i), first+int64(j), blocks[i:j]); err != nil {
+			return 0, err
+		}
+		i = j
+	}
+
+	n := 0
+	for i, b := range blocks {
+		start := (first + int64(i)) * bs
+		lo := off + int64(n) - start
+		n += copy(p[n:end-off], b[lo:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch requests blocks [from, to) and stores them in dst and the cache.
+func (r *HTTPReaderAt) fetch(from, to int64, dst [][]byte) error {
+	bs := r.opt.blockSize
+	start, end := from*bs, to*bs
+	if end > r.size {
+		end = r.size
+	}
+	resp, err := r.get(start, end-1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if etag := resp.Header.Get("ETag"); etag != r.etag {
+		return fmt.Errorf("ip2region: %s changed, ETag %s instead of %s", r.url, etag, r.etag)
+	}
+
+	buf := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return fmt.Errorf("ip2region: %s: %w", r.url, err)
+	}
+	for i := range dst {
+		b := buf[int64(i)*bs:]
+		if int64(len(b)) > bs {
+			b = b[:bs]
+		}
+		dst[i] = b
+		r.store(from+int64(i), b)
+	}
+	return nil
+}
+
+// get requests bytes [start, end] of the file.
+func (r *HTTPReaderAt) get(start, end int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := r.opt.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp, nil
+	case http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrRangeNotSupported, r.url)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("ip2region: %s: %s", r.url, resp.Status)
+	}
+}
+
+func (r *HTTPReaderAt) cached(n int64) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.blocks[n]; ok {
+		r.ll.MoveToFront(e)
+		return e.Value.(*remoteBlock).data
+	}
+	return nil
+}
+
+func (r *HTTPReaderAt) store(n int64, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.blocks[n]; ok {
+		r.ll.MoveToFront(e)
+		return
+	}
+	r.blocks[n] = r.ll.PushFront(&remoteBlock{n: n, data: data})
+	for r.ll.Len() > r.opt.cacheBlocks {
+		last := r.ll.Back()
+		r.ll.Remove(last)
+		delete(r.blocks, last.Value.(*remoteBlock).n)
+	}
+}
+
+// Close drops the cached blocks and closes the idle connections of the
+// client.
+func (r *HTTPReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ll.Init()
+	r.blocks = make(map[int64]*list.Element)
+	r.opt.client.CloseIdleConnections()
+	return nil
+}
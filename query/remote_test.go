@@ -0,0 +1,117 @@
+package ip2region
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeServer serves b with support for Range requests and counts the
+// requests made.
+func rangeServer(b []byte, etag *atomic.Value, requests *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(requests, 1)
+		w.Header().Set("ETag", etag.Load().(string))
+		http.ServeContent(w, req, "ip2region.db", time.Time{}, bytes.NewReader(b))
+	}))
+}
+
+func TestNewRemote(t *testing.T) {
+	b, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var requests int64
+	srv := rangeServer(b, &etag, &requests)
+	defer srv.Close()
+
+	region, err := NewRemote(srv.URL, WithBlockSize(4096), WithCacheBlocks(64))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer region.Close()
+
+	for i := 0; i < 200; i++ {
+		ip := IpLong2String(rand.Int63n(1 << 32))
+		want, _ := ipr.BtreeSearch(ip)
+		for _, search := range []func(string) (IpInfo, error){region.BtreeSearch, region.BinarySearch} {
+			got, err := search(ip)
+			if err != nil || got != want {
+				t.Fatalf("%s = %v, %v; want %v", ip, got, err, want)
+			}
+		}
+	}
+
+	// a repeated search is served from the block cache
+	region.BtreeSearch("1.2.3.4")
+	before := atomic.LoadInt64(&requests)
+	region.BtreeSearch("1.2.3.4")
+	if n := atomic.LoadInt64(&requests); n != before {
+		t.Fatalf("repeated search made %d requests", n-before)
+	}
+
+	small, err := NewRemote(srv.URL, WithBlockSize(512), WithCacheBlocks(1))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	etag.Store(`"v2"`)
+	if _, err := small.BtreeSearch("200.1.2.3"); err == nil {
+		t.Fatal("expected an error once the remote db changed")
+	}
+	small.Close()
+	etag.Store(`"v1"`)
+
+	if err := region.LoadToMemory(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want, _ := ipr.BtreeSearch("200.1.2.3")
+	if got, err := region.MemorySearch("200.1.2.3"); err != nil || got != want {
+		t.Fatalf("got %v, %v; want %v", got, err, want)
+	}
+}
+
+func TestHTTPReaderAt(t *testing.T) {
+	b := make([]byte, 10000)
+	rand.Read(b)
+	var etag atomic.Value
+	etag.Store("")
+	var requests int64
+	srv := rangeServer(b, &etag, &requests)
+	defer srv.Close()
+
+	r, err := NewHTTPReaderAt(srv.URL, WithBlockSize(1000), WithCacheBlocks(4))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if r.Size() != int64(len(b)) {
+		t.Fatalf("size %d, want %d", r.Size(), len(b))
+	}
+	for _, c := range []struct{ off, n int }{
+		{0, 10}, {990, 20}, {500, 3000}, {9990, 10}, {0, 10000}, {1234, 1},
+	} {
+		p := make([]byte, c.n)
+		if n, err := r.ReadAt(p, int64(c.off)); err != nil || n != c.n || !bytes.Equal(p, b[c.off:c.off+c.n]) {
+			t.Fatalf("ReadAt(%d, %d) = %d, %v", c.n, c.off, n, err)
+		}
+	}
+	p := make([]byte, 20)
+	if n, err := r.ReadAt(p, 9990); n != 10 || err == nil {
+		t.Fatalf("ReadAt past the end = %d, %v", n, err)
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(b)
+	}))
+	defer plain.Close()
+	if _, err := NewHTTPReaderAt(plain.URL); !errors.Is(err, ErrRangeNotSupported) {
+		t.Fatalf("expected ErrRangeNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package ip2region
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// Searcher looks up the region of an ip address. *Ip2Region implements it
+// with the algorithm chosen by its constructor, so code depending on
+// Searcher can switch algorithms by configuration or use a fake in tests.
+type Searcher interface {
+	Search(ipStr string) (IpInfo, error)
+	Close() error
+}
+
+var _ Searcher = (*Ip2Region)(nil)
+
+// Algorithm is the way a Searcher finds an ip in the db.
+type Algorithm int
+
+const (
+	// BtreeAlgorithm keeps the header blocks in memory and reads one run
+	// of index blocks plus the data block from the file per search.
+	BtreeAlgorithm Algorithm = iota
+	// BinaryAlgorithm reads one index block from the file per probe.
+	BinaryAlgorithm
+	// MemoryAlgorithm loads the whole db into memory.
+	MemoryAlgorithm
+	// MmapAlgorithm maps the db file into memory.
+	MmapAlgorithm
+)
+
+var algorithmNames = []string{"btree", "binary", "memory", "mmap"}
+
+func (a Algorithm) String() string {
+	if a < 0 || int(a) >= len(algorithmNames) {
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
+	return algorithmNames[a]
+}
+
+// ParseAlgorithm returns the Algorithm named "btree", "binary", "memory"
+// or "mmap".
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for i, n := range algorithmNames {
+		if n == name {
+			return Algorithm(i), nil
+		}
+	}
+	return 0, fmt.Errorf("ip2region: unknown algorithm %q", name)
+}
+
+type options struct {
+	algorithm Algorithm
+	codes     *CodeTables
+}
+
+// Option configures NewSearcher.
+type Option func(*options)
+
+// WithAlgorithm selects the search algorithm, the default is BtreeAlgorithm.
+func WithAlgorithm(a Algorithm) Option {
+	return func(o *options) {
+		o.algorithm = a
+	}
+}
+
+// WithBtree selects BtreeAlgorithm.
+func WithBtree() Option { return WithAlgorithm(BtreeAlgorithm) }
+
+// WithBinary selects BinaryAlgorithm.
+func WithBinary() Option { return WithAlgorithm(BinaryAlgorithm) }
+
+// WithMemory selects MemoryAlgorithm.
+func WithMemory() Option { return WithAlgorithm(MemoryAlgorithm) }
+
+// WithMmap selects MmapAlgorithm.
+func WithMmap() Option { return WithAlgorithm(MmapAlgorithm) }
+
+// WithCodeTables sets the code tables the ids of dbs without them, such as
+// upstream v1 dbs, are looked up in. Without code tables those ids are 0.
+func WithCodeTables(t *CodeTables) Option {
+	return func(o *options) {
+		o.codes = t
+	}
+}
+
+// NewSearcher opens the db file at path and returns a Searcher using the
+// configured algorithm. The in-memory algorithms load the db before
+// NewSearcher returns. MaxMind DB files are recognised by their metadata
+// and opened with OpenMMDB, whatever the algorithm, and upstream xdb files
+// by their header and opened with OpenXdb.
+func NewSearcher(path string, opts ...Option) (Searcher, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if isMMDB(path) {
+		return OpenMMDB(path)
+	}
+	if isXdbFile(path) {
+		return OpenXdb(path, opts...)
+	}
+
+	var ipr *Ip2Region
+	var err error
+	switch o.algorithm {
+	case BtreeAlgorithm, BinaryAlgorithm:
+		ipr, err = New(path)
+	case MemoryAlgorithm:
+		ipr, err = New(path)
+		if err == nil {
+			if err = ipr.LoadToMemory(); err != nil {
+				ipr.Close()
+			}
+		}
+	case MmapAlgorithm:
+		ipr, err = NewMmap(path)
+	default:
+		return nil, fmt.Errorf("ip2region: unknown algorithm %v", o.algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ipr.algorithm = o.algorithm
+	ipr.codes = o.codes
+	return ipr, nil
+}
+
+// NewBtreeSearcher is NewSearcher with BtreeAlgorithm.
+func NewBtreeSearcher(path string) (Searcher, error) {
+	return NewSearcher(path, WithBtree())
+}
+
+// NewBinarySearcher is NewSearcher with BinaryAlgorithm.
+func NewBinarySearcher(path string) (Searcher, error) {
+	return NewSearcher(path, WithBinary())
+}
+
+// NewMemorySearcher is NewSearcher with MemoryAlgorithm.
+func NewMemorySearcher(path string) (Searcher, error) {
+	return NewSearcher(path, WithMemory())
+}
+
+// NewMmapSearcher is NewSearcher with MmapAlgorithm.
+func NewMmapSearcher(path string) (Searcher, error) {
+	return NewSearcher(path, WithMmap())
+}
+
+// Algorithm returns the algorithm Search uses. Once the db has been loaded
+// into memory every search runs against it whatever the algorithm.
+func (ipr *Ip2Region) Algorithm() Algorithm {
+	return ipr.algorithm
+}
+
+// Search searches an IPv4 or IPv6 address with the algorithm chosen when
+// the Ip2Region was created; Ip2Regions from New use BtreeAlgorithm.
+func (ipr *Ip2Region) Search(ipStr string) (IpInfo, error) {
+	ip, ip6, err := parseIP(ipStr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	if ip6 != nil {
+		return ipr.search6(ip6)
+	}
+	return ipr.search(ip)
+}
+
+func (ipr *Ip2Region) search(ip int64) (IpInfo, error) {
+	blk, err := ipr.lookup(ip)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	return ipr.ipInfo(blk.dataPtr)
+}
+
+func (ipr *Ip2Region) search6(ip net.IP) (IpInfo, error) {
+	blk, err := ipr.lookup6(ip)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	return ipr.ipInfo(blk.dataPtr)
+}
+
+// lookup finds the index block of an IPv4 address with the algorithm of
+// ipr.
+func (ipr *Ip2Region) lookup(ip int64) (indexBlock, error) {
+	switch {
+	case atomic.LoadInt32(&ipr.inMemory) == 1:
+		return ipr.memoryLookup(ip)
+	case ipr.algorithm == BinaryAlgorithm:
+		return ipr.binaryLookup(ip)
+	default:
+		return ipr.btreeLookup(ip)
+	}
+}
+
+func (ipr *Ip2Region) lookup6(ip net.IP) (indexBlock, error) {
+	if atomic.LoadInt32(&ipr.inMemory) == 1 {
+		return ipr.memoryLookup6(ip)
+	}
+	return ipr.fileLookup6(ip)
+}
@@ -0,0 +1,88 @@
+package ip2region
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// fakeSearcher shows how code depending on Searcher can be tested.
+type fakeSearcher map[string]IpInfo
+
+func (f fakeSearcher) Search(ipStr string) (IpInfo, error) {
+	if info, ok := f[ipStr]; ok {
+		return info, nil
+	}
+	return IpInfo{}, ErrNotFound
+}
+
+func (f fakeSearcher) Close() error { return nil }
+
+func countryOf(s Searcher, ip string) string {
+	info, err := s.Search(ip)
+	if err != nil {
+		return ""
+	}
+	return info.Country
+}
+
+func TestNewSearcher(t *testing.T) {
+	for _, c := range []struct {
+		opts []Option
+		want Algorithm
+	}{
+		{nil, BtreeAlgorithm},
+		{[]Option{WithBtree()}, BtreeAlgorithm},
+		{[]Option{WithBinary()}, BinaryAlgorithm},
+		{[]Option{WithMemory()}, MemoryAlgorithm},
+		{[]Option{WithMmap()}, MmapAlgorithm},
+		{[]Option{WithMemory(), WithAlgorithm(BinaryAlgorithm)}, BinaryAlgorithm},
+	} {
+		s, err := NewSearcher(dbPath, c.opts...)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := s.(*Ip2Region).Algorithm(); got != c.want {
+			t.Fatalf("algorithm %v, want %v", got, c.want)
+		}
+		for i := 0; i < 200; i++ {
+			ip := IpLong2String(rand.Int63n(1 << 32))
+			want, _ := ipr.BtreeSearch(ip)
+			got, err := s.Search(ip)
+			if err != nil || got != want {
+				t.Fatalf("%v: Search(%s) = %v, %v; want %v", c.want, ip, got, err, want)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	if _, err := NewSearcher(dbPath, WithAlgorithm(Algorithm(42))); err == nil {
+		t.Fatal("expected an error for an unknown algorithm")
+	}
+	if _, err := NewMemorySearcher("does/not/exist.db"); err == nil {
+		t.Fatal("expected an error for a missing db")
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, a := range []Algorithm{BtreeAlgorithm, BinaryAlgorithm, MemoryAlgorithm, MmapAlgorithm} {
+		got, err := ParseAlgorithm(a.String())
+		if err != nil || got != a {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v", a.String(), got, err)
+		}
+	}
+	if _, err := ParseAlgorithm("quantum"); err == nil {
+		t.Error("expected an error for an unknown name")
+	}
+}
+
+func TestSearcher_fake(t *testing.T) {
+	var s Searcher = fakeSearcher{"1.2.3.4": {Country: "中国"}}
+	if got := countryOf(s, "1.2.3.4"); got != "中国" {
+		t.Fatalf("got %q", got)
+	}
+	if got := countryOf(s, "5.6.7.8"); got != "" {
+		t.Fatalf("got %q", got)
+	}
+}
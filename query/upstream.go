@@ -0,0 +1,122 @@
+package ip2region
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Upstream lionsoul ip2region v1 dbs share the super block, header blocks
+// and index blocks of this format, but their data blocks are
+//
+//	city_id(4 bytes)|国家|区域|省份|城市|ISP
+//
+// with a little endian city id and without the ids of this package, which
+// are looked up in the code tables of WithCodeTables instead.
+const (
+	// DataLayoutUpstream is the Metadata.DataLayout of dbs written with
+	// upstream v1 data blocks.
+	DataLayoutUpstream = "upstream-v1"
+
+	upstreamCityIdLength = 4
+	upstreamFields       = 5
+	nativeFields         = 7
+)
+
+// isNativeDataBlock reports whether b is a data block of this package,
+// seven fields of which the last three are numeric ids.
+func isNativeDataBlock(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	fields := strings.Split(string(b), "|")
+	if len(fields) != nativeFields {
+		return false
+	}
+	for _, id := range fields[4:] {
+		if _, err := strconv.Atoi(id); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// isUpstreamDataBlock reports whether b is an upstream v1 data block, a
+// city id followed by five fields.
+func isUpstreamDataBlock(b []byte) bool {
+	if len(b) < upstreamCityIdLength {
+		return false
+	}
+	region := b[upstreamCityIdLength:]
+	return utf8.Valid(region) && bytes.Count(region, []byte("|")) == upstreamFields-1
+}
+
+// sniffUpstream reports whether the data block of the first IPv4 index
+// block is in the upstream layout, for dbs without a metadata section to
+// say so.
+func sniffUpstream(data []byte) bool {
+	return !isNativeDataBlock(data) && isUpstreamDataBlock(data)
+}
+
+// detectLayout sets ipr.upstream from the metadata section or, for dbs
+// without one, from the first data block. A corrupt data pointer is left
+// for the searches to report.
+func (ipr *Ip2Region) detectLayout() error {
+	if ipr.metadata != nil {
+		ipr.upstream = ipr.metadata.DataLayout == DataLayoutUpstream
+		return nil
+	}
+	if ipr.lastIndexPtr < ipr.firstIndexPtr {
+		return nil
+	}
+
+	buffer := make([]byte, IndexBlockLength)
+	if err := ipr.readAt(buffer, ipr.firstIndexPtr, "index block"); err != nil {
+		return err
+	}
+	ptr, length, err := ipr.checkData(GetLong(buffer, 8))
+	if err != nil {
+		return nil
+	}
+	data := make([]byte, length)
+	if err := ipr.readAt(data, ptr, "data block"); err != nil {
+		return err
+	}
+	ipr.upstream = sniffUpstream(data)
+	return nil
+}
+
+// decodeIpInfo decodes a data block in the layout of the db.
+func (ipr *Ip2Region) decodeIpInfo(b []byte) IpInfo {
+	if !ipr.upstream {
+		return getIpInfo(b)
+	}
+	info := getUpstreamIpInfo(b)
+	ipr.codes.fill(&info)
+	return info
+}
+
+// getUpstreamIpInfo decodes an upstream v1 data block, the city id has no
+// IpInfo counterpart and is dropped.
+func getUpstreamIpInfo(b []byte) IpInfo {
+	if len(b) < upstreamCityIdLength {
+		return IpInfo{}
+	}
+	return getUpstreamRegion(b[upstreamCityIdLength:])
+}
+
+// getUpstreamRegion decodes an upstream region, 国家|区域|省份|城市|ISP.
+// The 区域 field has no IpInfo counterpart and is dropped.
+func getUpstreamRegion(b []byte) IpInfo {
+	fields := strings.Split(string(b), "|")
+	for len(fields) < upstreamFields {
+		fields = append(fields, "")
+	}
+	return IpInfo{
+		Country:  fields[0],
+		Province: fields[2],
+		City:     fields[3],
+		ISP:      fields[4],
+	}
+}
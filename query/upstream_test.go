@@ -0,0 +1,125 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// upstreamRecords are ranges of an upstream v1 db, whose data blocks start
+// with a little endian city id.
+func upstreamRecords() []testRecord {
+	return []testRecord{
+		{0, 0x00FFFFFF, "\x00\x00\x00\x000|0|0|内网IP|内网IP"},
+		{0x01000000, 0x01FFFFFF, "\xab\x08\x00\x00中国|0|广东省|深圳市|电信"},
+		{0x02000000, 0xFFFFFFFF, "\x02\x00\x00\x00中国|华北|北京|北京市|联通"},
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	if err := writeTestDB(path, upstreamRecords(), testDBOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	region, err := New(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	info, err := region.BtreeSearch("1.2.3.4")
+	region.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := IpInfo{Country: "中国", Province: "广东省", City: "深圳市", ISP: "电信"}
+	if info != want {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+
+	codes, err := ReadCodeTables(strings.NewReader("0,0,未知\n110000,11,北京\n440000,44,广东\n"), strings.NewReader("2,2,联通\n3,3,电信\n"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, a := range []Algorithm{BtreeAlgorithm, BinaryAlgorithm, MemoryAlgorithm, MmapAlgorithm} {
+		s, err := NewSearcher(path, WithAlgorithm(a), WithCodeTables(codes))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for ip, want := range map[string]IpInfo{
+			"1.2.3.4": {Country: "中国", Province: "广东省", City: "深圳市", ISP: "电信", RegionId: 440000, ProvinceId: 44, ISPId: 3},
+			"8.8.8.8": {Country: "中国", Province: "北京", City: "北京市", ISP: "联通", RegionId: 110000, ProvinceId: 11, ISPId: 2},
+			"0.0.0.1": {Country: "0", Province: "0", City: "内网IP", ISP: "内网IP"},
+		} {
+			info, err := s.Search(ip)
+			if err != nil {
+				t.Fatalf("%s: %v", a, err)
+			}
+			if info != want {
+				t.Errorf("%s: %s got %+v, want %+v", a, ip, info, want)
+			}
+		}
+		l, err := s.(*Ip2Region).Layout()
+		if err != nil || !l.Upstream {
+			t.Errorf("%s: layout %+v, %v", a, l, err)
+		}
+		s.Close()
+	}
+
+	if err := Verify(path); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := ReadCodeTables(strings.NewReader("x,0,未知\n"), nil); err == nil || !strings.Contains(err.Error(), "area code table line 1") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestUpstream_layout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the metadata section decides, a native db is not sniffed as upstream
+	native := filepath.Join(dir, "native.db")
+	if err := writeTestDB(native, testRecords(10), testDBOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	path := filepath.Join(dir, "meta.db")
+	err = writeTestDB(path, upstreamRecords(), testDBOptions{meta: &Metadata{FormatVersion: FormatVersion, DataLayout: DataLayoutUpstream}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for p, want := range map[string]bool{native: false, path: true} {
+		region, err := New(p)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if region.upstream != want {
+			t.Errorf("%s: upstream %v, want %v", p, region.upstream, want)
+		}
+		region.Close()
+		if err := Verify(p); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+
+	mismatch := filepath.Join(dir, "mismatch.db")
+	err = writeTestDB(mismatch, testRecords(10), testDBOptions{meta: &Metadata{FormatVersion: FormatVersion, DataLayout: DataLayoutUpstream}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var verr *VerifyError
+	if err := Verify(mismatch); !errors.As(err, &verr) || !strings.Contains(err.Error(), "data layout") {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package ip2region
+
+// The vector index is an optional section that maps the first two octets
+// of an ip to the index blocks covering that /16:
+//
+//	+------------+-----------+
+//	| 4bytes     | 4bytes    | x 256 x 256
+//	+------------+-----------+
+//	 first index ptr, last index ptr
+//
+// Both pointers are 0 when no range intersects the /16. With it a search
+// only has to binary search the handful of index blocks of one /16
+// instead of every block in the db.
+const (
+	SectionVectorIndex = "VECT"
+
+	VectorIndexLength = 8
+	VectorIndexSize   = 256 * 256 * VectorIndexLength
+)
@@ -0,0 +1,358 @@
+package ip2region
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// VerifyError lists every problem Verify found in a db. It matches
+// ErrCorruptDB with errors.Is.
+type VerifyError struct {
+	Problems []string
+}
+
+func (e *VerifyError) Error() string {
+	const shown = 5
+	msg := fmt.Sprintf("%v: %d problem(s): ", ErrCorruptDB, len(e.Problems))
+	if len(e.Problems) <= shown {
+		return msg + strings.Join(e.Problems, "; ")
+	}
+	return msg + strings.Join(e.Problems[:shown], "; ") + "; ..."
+}
+
+func (e *VerifyError) Unwrap() error {
+	return ErrCorruptDB
+}
+
+// Verify reads the whole db file at path and checks the super block
+// bounds, the order of the header blocks, that the index blocks are sorted
+// and do not overlap, that every data pointer is in bounds and every data
+// block decodes, and the layout of the optional sections. Xdb files are
+// checked by verifyXdb instead. It returns a *VerifyError listing all
+// problems found, or the error reading the file.
+func Verify(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return verifyDB(b)
+}
+
+type verifier struct {
+	b             []byte
+	firstIndexPtr int64
+	lastIndexPtr  int64
+	upstream      bool
+	data          map[int64]bool
+	problems      []string
+}
+
+func (v *verifier) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func verifyDB(b []byte) error {
+	v := &verifier{b: b, data: make(map[int64]bool)}
+	if isXdb(bytes.NewReader(b), int64(len(b))) {
+		v.verifyXdb()
+	} else {
+		v.verify()
+	}
+	if len(v.problems) > 0 {
+		return &VerifyError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (v *verifier) verify() {
+	size := int64(len(v.b))
+	if size < 8+TotalHeaderLength {
+		v.addf("db is %d bytes, too short for the super and header blocks", size)
+		return
+	}
+
+	// nothing else can be located without a sane super block, a db
+	// holding only IPv6 ranges has an empty IPv4 index
+	v.firstIndexPtr, v.lastIndexPtr = GetLong(v.b, 0), GetLong(v.b, 4)
+	if v.firstIndexPtr < 8+TotalHeaderLength || v.lastIndexPtr+IndexBlockLength < v.firstIndexPtr ||
+		(v.lastIndexPtr-v.firstIndexPtr)%IndexBlockLength != 0 ||
+		v.lastIndexPtr+IndexBlockLength > size {
+		v.addf("super block index pointers %d-%d out of bounds for a %d byte db", v.firstIndexPtr, v.lastIndexPtr, size)
+		return
+	}
+
+	if v.lastIndexPtr >= v.firstIndexPtr {
+		ptr, length := v.dataBlock(GetLong(v.b, v.firstIndexPtr+8))
+		v.upstream = ptr+length <= size && sniffUpstream(v.b[ptr:ptr+length])
+	}
+
+	v.verifyHeader()
+	v.verifyIndex()
+	v.verifySections()
+}
+
+func (v *verifier) verifyHeader() {
+	var prevSip, prevPtr int64
+	for i := int64(0); i < TotalHeaderLength/8; i++ {
+		sip, ptr := GetLong(v.b, 8+i*8), GetLong(v.b, 12+i*8)
+		if ptr == 0 {
+			if i == 0 && v.lastIndexPtr >= v.firstIndexPtr {
+				v.addf("header blocks are empty")
+			}
+			return
+		}
+
+		switch {
+		case ptr < v.firstIndexPtr || ptr > v.lastIndexPtr+IndexBlockLength ||
+			(ptr-v.firstIndexPtr)%IndexBlockLength != 0:
+			v.addf("header block %d points to %d outside the index", i, ptr)
+		case ptr <= v.lastIndexPtr && GetLong(v.b, ptr) != sip:
+			v.addf("header block %d start ip %s does not match index block at %d", i, IpLong2String(sip), ptr)
+		case ptr > v.lastIndexPtr && GetLong(v.b, v.lastIndexPtr) != sip:
+			v.addf("header block %d start ip %s does not match the last index block", i, IpLong2String(sip))
+		}
+		if i > 0 && (sip < prevSip || ptr < prevPtr) {
+			v.addf("header block %d is out of order", i)
+		}
+		prevSip, prevPtr = sip, ptr
+	}
+}
+
+func (v *verifier) verifyIndex() {
+	var prev int64 = -1
+	for p := v.firstIndexPtr; p <= v.lastIndexPtr; p += IndexBlockLength {
+		blk := indexBlockAt(v.b, p)
+		start, end := GetLong(v.b, p), GetLong(v.b, p+4)
+		if end < start {
+			v.addf("index block at %d has start ip %s after end ip %s", p, blk.startIP, blk.endIP)
+		}
+		if start <= prev {
+			v.addf("index block at %d starting at %s is out of order or overlaps the previous block", p, blk.startIP)
+		}
+		prev = end
+		v.verifyData(p, blk.dataPtr)
+	}
+}
+
+// dataBlock splits an index data pointer into the data block offset and
+// length.
+func (v *verifier) dataBlock(dataPtr int64) (ptr, length int64) {
+	return dataPtr & 0x00FFFFFF, (dataPtr >> 24) & 0xFF
+}
+
+func (v *verifier) verifyData(p, dataPtr int64) {
+	ptr, length := v.dataBlock(dataPtr)
+	if ptr < 8+TotalHeaderLength || ptr+length > v.firstIndexPtr {
+		v.addf("index block at %d has data block %d+%d outside the data area", p, ptr, length)
+		return
+	}
+	if checked := v.data[dataPtr]; checked {
+		return
+	}
+	v.data[dataPtr] = true
+
+	line := v.b[ptr : ptr+length]
+	if v.upstream {
+		v.verifyUpstreamData(ptr, line)
+		return
+	}
+	if !utf8.Valid(line) {
+		v.addf("data block at %d is not valid utf-8", ptr)
+		return
+	}
+	fields := strings.Split(string(line), "|")
+	if len(fields) != 7 {
+		v.addf("data block at %d has %d fields, want 7: %q", ptr, len(fields), line)
+		return
+	}
+	for _, id := range fields[4:] {
+		if _, err := strconv.Atoi(id); err != nil {
+			v.addf("data block at %d has a non numeric id: %q", ptr, line)
+			return
+		}
+	}
+}
+
+// verifyUpstreamData checks an upstream v1 data block, a city id and five
+// fields.
+func (v *verifier) verifyUpstreamData(ptr int64, line []byte) {
+	if len(line) < upstreamCityIdLength {
+		v.addf("data block at %d is %d bytes, too short for a city id", ptr, len(line))
+		return
+	}
+	region := line[upstreamCityIdLength:]
+	if !utf8.Valid(region) {
+		v.addf("data block at %d is not valid utf-8", ptr)
+		return
+	}
+	if n := strings.Count(string(region), "|") + 1; n != upstreamFields {
+		v.addf("data block at %d has %d fields, want %d: %q", ptr, n, upstreamFields, region)
+	}
+}
+
+func (v *verifier) verifySections() {
+	size := int64(len(v.b))
+	seen := make(map[string]bool)
+	for off := v.lastIndexPtr + IndexBlockLength; off+SectionHeaderLength <= size; {
+		tag := string(v.b[off : off+4])
+		if !isSectionTag(v.b[off : off+4]) {
+			return
+		}
+		length := GetLong(v.b, off+4)
+		ptr := off + SectionHeaderLength
+		if ptr+length > size {
+			v.addf("section %s at %d is %d bytes, past the end of the db", tag, off, length)
+			return
+		}
+		if seen[tag] {
+			v.addf("section %s at %d is duplicated", tag, off)
+		}
+		seen[tag] = true
+
+		switch tag {
+		case SectionVectorIndex:
+			v.verifyVector(ptr, length)
+		case SectionIPv6Index:
+			v.verifyIndex6(ptr, length)
+		case SectionMetadata:
+			v.verifyMetadata(off, ptr, length)
+		}
+		off = ptr + length
+	}
+}
+
+func (v *verifier) verifyVector(ptr, length int64) {
+	if length != VectorIndexSize {
+		v.addf("vector index is %d bytes, want %d", length, VectorIndexSize)
+		return
+	}
+	for k := int64(0); k < 256*256; k++ {
+		sptr := GetLong(v.b, ptr+k*VectorIndexLength)
+		eptr := GetLong(v.b, ptr+k*VectorIndexLength+4)
+		if sptr == 0 && eptr == 0 {
+			continue
+		}
+		if sptr < v.firstIndexPtr || eptr > v.lastIndexPtr || eptr < sptr ||
+			(sptr-v.firstIndexPtr)%IndexBlockLength != 0 || (eptr-sptr)%IndexBlockLength != 0 {
+			v.addf("vector index entry %d.%d points to %d-%d outside the index", k>>8, k&0xFF, sptr, eptr)
+			continue
+		}
+		if GetLong(v.b, sptr+4) < k<<16 || GetLong(v.b, eptr) > k<<16|0xFFFF {
+			v.addf("vector index entry %d.%d points to index blocks outside its /16", k>>8, k&0xFF)
+		}
+	}
+}
+
+func (v *verifier) verifyIndex6(ptr, length int64) {
+	if length%IndexBlock6Length != 0 {
+		v.addf("ipv6 index length %d is not a multiple of %d", length, IndexBlock6Length)
+		return
+	}
+	var prev netip.Addr
+	for p := ptr; p < ptr+length; p += IndexBlock6Length {
+		blk := indexBlock6At(v.b, p)
+		if blk.startIP.Is4In6() || blk.endIP.Is4In6() {
+			v.addf("ipv6 index block at %d holds an ipv4 range", p)
+		}
+		if blk.endIP.Less(blk.startIP) {
+			v.addf("ipv6 index block at %d has start ip %s after end ip %s", p, blk.startIP, blk.endIP)
+		}
+		if prev.IsValid() && !prev.Less(blk.startIP) {
+			v.addf("ipv6 index block at %d starting at %s is out of order or overlaps the previous block", p, blk.startIP)
+		}
+		prev = blk.endIP
+		v.verifyData(p, blk.dataPtr)
+	}
+}
+
+// verifyMetadata decodes the metadata section at off and checks its
+// checksum.
+func (v *verifier) verifyMetadata(off, ptr, length int64) {
+	md, err := decodeMetadata(v.b[ptr : ptr+length])
+	if err != nil {
+		v.addf("%v", err)
+		return
+	}
+	if !checksumSupported(md.Checksum) {
+		v.addf("metadata checksum %q has an unknown algorithm", md.Checksum)
+		return
+	}
+	if upstream := md.DataLayout == DataLayoutUpstream; upstream != v.upstream {
+		v.addf("metadata data layout %q does not match the data blocks", md.DataLayout)
+	}
+	sum, _ := checksum(bytes.NewReader(v.b[:off]))
+	if sum != md.Checksum {
+		v.addf("checksum %s does not match metadata checksum %s", sum, md.Checksum)
+	}
+}
+
+// verifyXdb checks the segment index blocks of an xdb file: that they are
+// sorted, do not overlap or cross a /16, are covered by the vector index
+// entry of their /16 and that their regions decode. It also checks the
+// bounds of every vector index entry.
+func (v *verifier) verifyXdb() {
+	h, err := parseXdbHeader(v.b, int64(len(v.b)))
+	if err != nil {
+		v.addf("%v", err)
+		return
+	}
+
+	for k := int64(0); k < 256*256; k++ {
+		sptr := GetLong(v.b, XdbHeaderLength+k*XdbVectorIndexLength)
+		eptr := GetLong(v.b, XdbHeaderLength+k*XdbVectorIndexLength+4)
+		if sptr == eptr {
+			continue
+		}
+		if sptr < h.StartIndexPtr || eptr > h.EndIndexPtr+XdbSegmentIndexLength || eptr < sptr ||
+			(sptr-h.StartIndexPtr)%XdbSegmentIndexLength != 0 || (eptr-sptr)%XdbSegmentIndexLength != 0 {
+			v.addf("xdb vector index entry %d.%d points to %d-%d outside the segment index", k>>8, k&0xFF, sptr, eptr)
+		}
+	}
+
+	var prev int64 = -1
+	for p := h.StartIndexPtr; p <= h.EndIndexPtr; p += XdbSegmentIndexLength {
+		seg := xdbSegmentAt(v.b, p)
+		switch {
+		case seg.endIP < seg.startIP:
+			v.addf("xdb segment index block at %d has start ip %s after end ip %s", p, IpLong2String(seg.startIP), IpLong2String(seg.endIP))
+		case seg.startIP>>16 != seg.endIP>>16:
+			v.addf("xdb segment index block at %d crosses a /16", p)
+		}
+		if seg.startIP <= prev {
+			v.addf("xdb segment index block at %d starting at %s is out of order or overlaps the previous block", p, IpLong2String(seg.startIP))
+		}
+		prev = seg.endIP
+
+		vp := XdbHeaderLength + (seg.startIP>>16)*XdbVectorIndexLength
+		if p < GetLong(v.b, vp) || p >= GetLong(v.b, vp+4) {
+			v.addf("xdb segment index block at %d is not covered by the vector index entry of its /16", p)
+		}
+		v.verifyXdbRegion(p, seg, h.StartIndexPtr)
+	}
+}
+
+// verifyXdbRegion checks the region of the segment index block at p.
+func (v *verifier) verifyXdbRegion(p int64, seg xdbSegment, end int64) {
+	if seg.dataPtr < xdbDataPtr || seg.dataPtr+seg.dataLen > end {
+		v.addf("xdb segment index block at %d has region %d+%d outside the region data", p, seg.dataPtr, seg.dataLen)
+		return
+	}
+	if v.data[seg.dataPtr] {
+		return
+	}
+	v.data[seg.dataPtr] = true
+
+	region := v.b[seg.dataPtr : seg.dataPtr+seg.dataLen]
+	if !utf8.Valid(region) {
+		v.addf("xdb region at %d is not valid utf-8", seg.dataPtr)
+		return
+	}
+	if n := bytes.Count(region, []byte("|")) + 1; n != upstreamFields {
+		v.addf("xdb region at %d has %d fields, want %d: %q", seg.dataPtr, n, upstreamFields, region)
+	}
+}
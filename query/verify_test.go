@@ -0,0 +1,76 @@
+package ip2region
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip.db")
+	if err := writeTestDB(path, testRecords(4096), testDBOptions{
+		vector: true,
+		ipv6: []testRecord6{
+			{"2001:db8::", "2001:db8::ffff", "中国|北京|北京市|联通|1|11|2"},
+			{"2001:db8:1::", "2001:db8:1::ffff", "美国|0|0|0|0|0|0"},
+		},
+	}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := Verify(path); err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	firstIndexPtr := GetLong(b, 0)
+	dataPtr := GetLong(b, firstIndexPtr+8) & 0x00FFFFFF
+	for _, c := range []struct {
+		name    string
+		corrupt func(b []byte) []byte
+		want    []string
+	}{
+		{"truncated", func(b []byte) []byte { return b[:firstIndexPtr+100] }, []string{"super block"}},
+		{"short", func(b []byte) []byte { return b[:100] }, []string{"too short"}},
+		{"unsorted index", func(b []byte) []byte {
+			writeIntLong(b, int(firstIndexPtr+IndexBlockLength), 0)
+			return b
+		}, []string{"out of order"}},
+		{"data pointer and block", func(b []byte) []byte {
+			writeIntLong(b, int(firstIndexPtr+8), firstIndexPtr|4<<24)
+			copy(b[dataPtr:], "中国,")
+			return b
+		}, []string{"outside the data area", "has 6 fields"}},
+		{"header order", func(b []byte) []byte {
+			writeIntLong(b, 8+8+4, GetLong(b, 8+4))
+			return b
+		}, []string{"header block 1"}},
+	} {
+		bad := c.corrupt(append([]byte(nil), b...))
+		err := verifyDB(bad)
+		var verr *VerifyError
+		if !errors.As(err, &verr) || !errors.Is(err, ErrCorruptDB) {
+			t.Fatalf("%s: expected a *VerifyError, got %v", c.name, err)
+		}
+		all := strings.Join(verr.Problems, "\n")
+		for _, want := range c.want {
+			if !strings.Contains(all, want) {
+				t.Errorf("%s: problems do not mention %q:\n%s", c.name, want, all)
+			}
+		}
+	}
+
+	if err := Verify(filepath.Join(dir, "missing.db")); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+}
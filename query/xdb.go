@@ -0,0 +1,423 @@
+package ip2region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// Upstream ip2region xdb (v2) files are a 256 byte header, a vector index
+// locating the segment index blocks of every /16, the region strings and
+// the segment index blocks sorted by start ip:
+//
+//	header:        version(2)|index policy(2)|created at(4)|start index ptr(4)|end index ptr(4)|zeros
+//	vector index:  256*256 entries of start ptr(4)|end ptr(4), the end ptr is exclusive
+//	segment index: start ip(4)|end ip(4)|data length(2)|data ptr(4)
+//
+// All integers are little endian and the regions are 国家|区域|省份|城市|ISP
+// strings. The ranges of the segment index blocks never cross a /16, so
+// that every block is found from the vector index entry of its /16.
+const (
+	XdbVersion = 2
+
+	// index policies recorded in the header, searchers use the vector
+	// index whatever the policy
+	XdbVectorIndexPolicy = 1
+	XdbBTreeIndexPolicy  = 2
+
+	XdbHeaderLength       = 256
+	XdbVectorIndexLength  = 8
+	XdbVectorIndexSize    = 256 * 256 * XdbVectorIndexLength
+	XdbSegmentIndexLength = 14
+
+	// the region strings start after the vector index
+	xdbDataPtr = XdbHeaderLength + XdbVectorIndexSize
+	// segment index blocks read at once by Iterate
+	xdbIterateBlocks = 4096
+)
+
+// XdbHeader is the header of an xdb file.
+type XdbHeader struct {
+	Version     uint16
+	IndexPolicy uint16
+	CreatedAt   time.Time
+	// StartIndexPtr and EndIndexPtr point to the first and the last
+	// segment index block.
+	StartIndexPtr int64
+	EndIndexPtr   int64
+}
+
+// Xdb searches an upstream ip2region xdb file with the same methods as
+// Ip2Region. The header and the vector index are read when it is opened,
+// so it is safe for concurrent use.
+type Xdb struct {
+	reader io.ReaderAt
+	closer io.Closer
+	size   int64
+
+	header XdbHeader
+	vector []byte
+	codes  *CodeTables
+
+	// decoded regions by data pointer
+	infoMu sync.RWMutex
+	infos  map[int64]IpInfo
+}
+
+var _ RangeSearcher = (*Xdb)(nil)
+
+// OpenXdb opens the xdb file at path. The configured algorithm decides
+// whether the segment index blocks and regions are read from the file,
+// for BtreeAlgorithm and BinaryAlgorithm, from a copy of it in memory, for
+// MemoryAlgorithm, or from the file mapped into memory, for MmapAlgorithm.
+// The ids of the regions are looked up in the code tables of
+// WithCodeTables.
+func OpenXdb(path string, opts ...Option) (*Xdb, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var r io.ReaderAt = file
+	var closer io.Closer = file
+	if o.algorithm == MmapAlgorithm {
+		m, err := newMmapReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		r, closer = m, m
+	}
+	x, err := newXdb(r, fi.Size(), o)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+	if x.reader == r {
+		x.closer = closer
+	} else if err := closer.Close(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// NewXdbFromReaderAt is OpenXdb for the size byte xdb in r. The mmap
+// algorithm needs an xdb file and is not supported.
+func NewXdbFromReaderAt(r io.ReaderAt, size int64, opts ...Option) (*Xdb, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.algorithm == MmapAlgorithm {
+		return nil, fmt.Errorf("ip2region: algorithm %v is not supported for an io.ReaderAt", o.algorithm)
+	}
+	return newXdb(r, size, o)
+}
+
+// newXdb reads the header and vector index of the xdb in r, a mapped file
+// for MmapAlgorithm.
+func newXdb(r io.ReaderAt, size int64, o options) (*Xdb, error) {
+	b := make([]byte, xdbDataPtr)
+	if err := readFullAt(r, b, 0, "xdb header"); err != nil {
+		return nil, err
+	}
+	header, err := parseXdbHeader(b, size)
+	if err != nil {
+		return nil, err
+	}
+	x := &Xdb{reader: r, size: size, header: header, vector: b[XdbHeaderLength:], codes: o.codes}
+
+	switch o.algorithm {
+	case BtreeAlgorithm, BinaryAlgorithm, MmapAlgorithm:
+	case MemoryAlgorithm:
+		content := make([]byte, size)
+		if err := readFullAt(r, content, 0, "xdb"); err != nil {
+			return nil, err
+		}
+		x.reader = bytes.NewReader(content)
+	default:
+		return nil, fmt.Errorf("ip2region: unknown algorithm %v", o.algorithm)
+	}
+	return x, nil
+}
+
+func parseXdbHeader(b []byte, size int64) (XdbHeader, error) {
+	h := XdbHeader{
+		Version:       binary.LittleEndian.Uint16(b),
+		IndexPolicy:   binary.LittleEndian.Uint16(b[2:]),
+		CreatedAt:     time.Unix(GetLong(b, 4), 0).UTC(),
+		StartIndexPtr: GetLong(b, 8),
+		EndIndexPtr:   GetLong(b, 12),
+	}
+	if h.Version != XdbVersion {
+		return XdbHeader{}, fmt.Errorf("%w: xdb version %d", ErrUnsupportedFormat, h.Version)
+	}
+	if h.StartIndexPtr < xdbDataPtr || h.EndIndexPtr < h.StartIndexPtr ||
+		(h.EndIndexPtr-h.StartIndexPtr)%XdbSegmentIndexLength != 0 ||
+		h.EndIndexPtr+XdbSegmentIndexLength > size {
+		return XdbHeader{}, corruptf("xdb segment index pointers %d-%d out of bounds", h.StartIndexPtr, h.EndIndexPtr)
+	}
+	return h, nil
+}
+
+// isXdb reports whether the size byte file in r looks like an xdb file: a
+// known version and index policy, and segment index pointers ending at the
+// end of the file. Neither db nor MMDB files start that way.
+func isXdb(r io.ReaderAt, size int64) bool {
+	b := make([]byte, 16)
+	if size < xdbDataPtr || readFullAt(r, b, 0, "xdb header") != nil {
+		return false
+	}
+	policy := binary.LittleEndian.Uint16(b[2:])
+	return binary.LittleEndian.Uint16(b) == XdbVersion &&
+		(policy == XdbVectorIndexPolicy || policy == XdbBTreeIndexPolicy) &&
+		GetLong(b, 8) >= xdbDataPtr && GetLong(b, 12) >= GetLong(b, 8) &&
+		(GetLong(b, 12)-GetLong(b, 8))%XdbSegmentIndexLength == 0 &&
+		GetLong(b, 12)+XdbSegmentIndexLength == size
+}
+
+// isXdbFile is isXdb for the file at path.
+func isXdbFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return isXdb(f, fi.Size())
+}
+
+// Header returns the header of the xdb file.
+func (x *Xdb) Header() XdbHeader {
+	return x.header
+}
+
+// xdbSegment is a decoded segment index block.
+type xdbSegment struct {
+	startIP int64
+	endIP   int64
+	dataPtr int64
+	dataLen int64
+}
+
+func xdbSegmentAt(b []byte, off int64) xdbSegment {
+	return xdbSegment{
+		startIP: GetLong(b, off),
+		endIP:   GetLong(b, off+4),
+		dataLen: int64(binary.LittleEndian.Uint16(b[off+8:])),
+		dataPtr: GetLong(b, off+10),
+	}
+}
+
+// lookup reads the segment index blocks of the /16 of ip, as located by
+// the vector index, and binary searches them.
+func (x *Xdb) lookup(ip int64) (xdbSegment, error) {
+	p := (ip >> 16) * XdbVectorIndexLength
+	sptr, eptr := GetLong(x.vector, p), GetLong(x.vector, p+4)
+	if sptr == eptr {
+		return xdbSegment{}, ErrNotFound
+	}
+	if sptr < x.header.StartIndexPtr || eptr > x.header.EndIndexPtr+XdbSegmentIndexLength || eptr < sptr ||
+		(sptr-x.header.StartIndexPtr)%XdbSegmentIndexLength != 0 || (eptr-sptr)%XdbSegmentIndexLength != 0 {
+		return xdbSegment{}, corruptf("xdb vector index entry %d.%d points to %d-%d outside the segment index", ip>>24, ip>>16&0xFF, sptr, eptr)
+	}
+
+	index := make([]byte, eptr-sptr)
+	if err := readFullAt(x.reader, index, sptr, "xdb segment index"); err != nil {
+		return xdbSegment{}, err
+	}
+	l, h := int64(0), int64(len(index))/XdbSegmentIndexLength-1
+	for l <= h {
+		m := (l + h) >> 1
+		seg := xdbSegmentAt(index, m*XdbSegmentIndexLength)
+		switch {
+		case ip < seg.startIP:
+			h = m - 1
+		case ip > seg.endIP:
+			l = m + 1
+		default:
+			return seg, nil
+		}
+	}
+	return xdbSegment{}, ErrNotFound
+}
+
+// ipInfo reads and decodes the region of seg.
+func (x *Xdb) ipInfo(seg xdbSegment) (IpInfo, error) {
+	x.infoMu.RLock()
+	info, ok := x.infos[seg.dataPtr]
+	x.infoMu.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	if seg.dataPtr < xdbDataPtr || seg.dataPtr+seg.dataLen > x.header.StartIndexPtr {
+		return IpInfo{}, corruptf("xdb region %d+%d is outside the region data", seg.dataPtr, seg.dataLen)
+	}
+	b := make([]byte, seg.dataLen)
+	if err := readFullAt(x.reader, b, seg.dataPtr, "xdb region"); err != nil {
+		return IpInfo{}, err
+	}
+	info = getUpstreamRegion(b)
+	x.codes.fill(&info)
+
+	x.infoMu.Lock()
+	if x.infos == nil {
+		x.infos = make(map[int64]IpInfo)
+	}
+	x.infos[seg.dataPtr] = info
+	x.infoMu.Unlock()
+	return info, nil
+}
+
+// Search searches an IPv4 address, xdb files hold no IPv6 ranges and
+// IPv6 addresses are not found.
+func (x *Xdb) Search(ipStr string) (IpInfo, error) {
+	addr, err := parseAddr(ipStr)
+	if err != nil {
+		return IpInfo{}, err
+	}
+	return x.SearchAddr(addr)
+}
+
+// SearchAddr searches an IPv4 or IPv4-mapped IPv6 address.
+func (x *Xdb) SearchAddr(addr netip.Addr) (IpInfo, error) {
+	r, err := x.SearchAddrRange(addr)
+	return r.IpInfo, err
+}
+
+// SearchIP searches a 4 or 16 byte net.IP.
+func (x *Xdb) SearchIP(ip net.IP) (IpInfo, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return IpInfo{}, &ParseError{Input: ip.String(), Msg: "invalid address"}
+	}
+	return x.SearchAddr(addr)
+}
+
+// SearchRange is Search returning the segment holding the ip along with
+// its IpInfo. Segments end at /16 boundaries, so adjacent segments may
+// share a region.
+func (x *Xdb) SearchRange(ipStr string) (Range, error) {
+	addr, err := parseAddr(ipStr)
+	if err != nil {
+		return Range{}, err
+	}
+	return x.SearchAddrRange(addr)
+}
+
+// SearchAddrRange is SearchAddr returning the segment holding the ip along
+// with its IpInfo.
+func (x *Xdb) SearchAddrRange(addr netip.Addr) (Range, error) {
+	if !addr.IsValid() {
+		return Range{}, &ParseError{Input: addr.String(), Msg: "invalid address"}
+	}
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return Range{}, ErrNotFound
+	}
+	b := addr.As4()
+	seg, err := x.lookup(int64(binary.BigEndian.Uint32(b[:])))
+	if err != nil {
+		return Range{}, err
+	}
+	info, err := x.ipInfo(seg)
+	if err != nil {
+		return Range{}, err
+	}
+	return Range{Start: long2Addr(seg.startIP), End: long2Addr(seg.endIP), IpInfo: info}, nil
+}
+
+// Iterate calls fn for every range of the file in address order. Adjacent
+// segments with the same IpInfo, such as the parts of a range split at
+// /16 boundaries, are joined.
+func (x *Xdb) Iterate(fn func(r Range) error) error {
+	var pending Range
+	var pendingEnd int64
+	blocks := (x.header.EndIndexPtr-x.header.StartIndexPtr)/XdbSegmentIndexLength + 1
+	buffer := make([]byte, xdbIterateBlocks*XdbSegmentIndexLength)
+	for i := int64(0); i < blocks; i += xdbIterateBlocks {
+		n := blocks - i
+		if n > xdbIterateBlocks {
+			n = xdbIterateBlocks
+		}
+		index := buffer[:n*XdbSegmentIndexLength]
+		if err := readFullAt(x.reader, index, x.header.StartIndexPtr+i*XdbSegmentIndexLength, "xdb segment index"); err != nil {
+			return err
+		}
+		for off := int64(0); off < int64(len(index)); off += XdbSegmentIndexLength {
+			seg := xdbSegmentAt(index, off)
+			info, err := x.ipInfo(seg)
+			if err != nil {
+				return err
+			}
+			if pending.Start.IsValid() && pending.IpInfo == info && pendingEnd+1 == seg.startIP {
+				pending.End, pendingEnd = long2Addr(seg.endIP), seg.endIP
+				continue
+			}
+			if pending.Start.IsValid() {
+				if err := fn(pending); err != nil {
+					return err
+				}
+			}
+			pending = Range{Start: long2Addr(seg.startIP), End: long2Addr(seg.endIP), IpInfo: info}
+			pendingEnd = seg.endIP
+		}
+	}
+	if pending.Start.IsValid() {
+		return fn(pending)
+	}
+	return nil
+}
+
+// Ranges returns every range of the file in the order of Iterate.
+func (x *Xdb) Ranges() ([]Range, error) {
+	var ranges []Range
+	err := x.Iterate(func(r Range) error {
+		ranges = append(ranges, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// Export writes every range of the file to w in format, in the order of
+// Iterate.
+func (x *Xdb) Export(w io.Writer, format ExportFormat) error {
+	rw, err := NewRangeWriter(w, format)
+	if err != nil {
+		return err
+	}
+	if err := x.Iterate(rw.Write); err != nil {
+		return err
+	}
+	return rw.Flush()
+}
+
+// Close closes the xdb file, if it is still open.
+func (x *Xdb) Close() error {
+	if x.closer == nil {
+		return nil
+	}
+	return x.closer.Close()
+}
@@ -0,0 +1,154 @@
+package ip2region
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// xdbRecords are the ranges of the test xdb, the second one spans three
+// /16s.
+func xdbRecords() []testRecord {
+	return []testRecord{
+		{0, 0x00FFFFFF, "0|0|0|内网IP|内网IP"},
+		{0x01000000, 0x0102FFFF, "中国|0|广东省|深圳市|电信"},
+		{0x01030000, 0xFFFFFFFF, "中国|0|北京|北京市|联通"},
+	}
+}
+
+func TestXdb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip2region.xdb")
+	if err := ioutil.WriteFile(path, testXdb(xdbRecords()), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	codes, err := ReadCodeTables(strings.NewReader("110000,11,北京\n440000,44,广东\n"), strings.NewReader("2,2,联通\n3,3,电信\n"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, a := range []Algorithm{BtreeAlgorithm, BinaryAlgorithm, MemoryAlgorithm, MmapAlgorithm} {
+		s, err := NewSearcher(path, WithAlgorithm(a), WithCodeTables(codes))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		x, ok := s.(*Xdb)
+		if !ok {
+			t.Fatalf("%s: got a %T", a, s)
+		}
+		if h := x.Header(); h.Version != XdbVersion || h.IndexPolicy != XdbVectorIndexPolicy || h.CreatedAt.Unix() != 1714550400 {
+			t.Fatalf("header %+v", h)
+		}
+		for ip, want := range map[string]IpInfo{
+			"1.2.3.4": {Country: "中国", Province: "广东省", City: "深圳市", ISP: "电信", RegionId: 440000, ProvinceId: 44, ISPId: 3},
+			"8.8.8.8": {Country: "中国", Province: "北京", City: "北京市", ISP: "联通", RegionId: 110000, ProvinceId: 11, ISPId: 2},
+			"0.0.0.0": {Country: "0", Province: "0", City: "内网IP", ISP: "内网IP"},
+		} {
+			info, err := s.Search(ip)
+			if err != nil {
+				t.Fatalf("%s: %v", a, err)
+			}
+			if info != want {
+				t.Errorf("%s: %s got %+v, want %+v", a, ip, info, want)
+			}
+		}
+		r, err := x.SearchRange("1.1.5.5")
+		if err != nil || r.Start.String() != "1.1.0.0" || r.End.String() != "1.1.255.255" {
+			t.Errorf("%s: range %+v, %v", a, r, err)
+		}
+		if _, err := x.Search("2001:db8::1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: ipv6 search got %v", a, err)
+		}
+		if _, err := x.Search("1.2.3.x"); !errors.Is(err, ErrInvalidIP) {
+			t.Errorf("%s: invalid ip got %v", a, err)
+		}
+
+		ranges, err := x.Ranges()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(ranges) != 3 || ranges[1].Start.String() != "1.0.0.0" || ranges[1].End.String() != "1.2.255.255" || ranges[2].End.String() != "255.255.255.255" {
+			t.Errorf("%s: ranges %+v", a, ranges)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// the mmap algorithm maps the file and fails once it is closed
+	x, err := OpenXdb(path, WithMmap())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := x.reader.(*mmapReader); !ok {
+		t.Fatalf("mmap xdb read through a %T", x.reader)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := x.Search("1.2.3.4"); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("search after Close = %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := NewSearcherFromReaderAt(bytes.NewReader(b), int64(len(b)), WithMmap()); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("mmap of an io.ReaderAt got %v", err)
+	}
+	s, err := NewSearcherFromReaderAt(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if info, err := s.Search("1.2.3.4"); err != nil || info.City != "深圳市" || info.ISPId != 0 {
+		t.Fatalf("got %+v, %v", info, err)
+	}
+	if err := Verify(path); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestXdb_corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ip2region")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ip2region.xdb")
+	b := testXdb(xdbRecords())
+
+	// the vector index entry of 1.2.0.0/16 pointing into the regions
+	corrupt := append([]byte(nil), b...)
+	writeIntLong(corrupt, XdbHeaderLength+0x0102*XdbVectorIndexLength, XdbHeaderLength+XdbVectorIndexSize)
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	x, err := OpenXdb(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := x.Search("1.2.3.4"); !errors.Is(err, ErrCorruptDB) {
+		t.Errorf("got %v, want ErrCorruptDB", err)
+	}
+	x.Close()
+	var verr *VerifyError
+	if err := Verify(path); !errors.As(err, &verr) || !strings.Contains(err.Error(), "vector index entry 1.2") {
+		t.Errorf("got %v", err)
+	}
+
+	// a newer version
+	corrupt = append([]byte(nil), b...)
+	corrupt[0] = 3
+	if _, err := NewXdbFromReaderAt(bytes.NewReader(corrupt), int64(len(corrupt))); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v, want ErrUnsupportedFormat", err)
+	}
+}